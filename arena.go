@@ -1,32 +1,43 @@
 package bigqueue
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"syscall"
+	"unsafe"
+)
 
-	"github.com/grandecola/mmap"
+var (
+	// errUnmappedMemory is the panic raised when an arena is accessed after it is unmapped.
+	errUnmappedMemory = errors.New("unmapped memory")
+	// errIndexOutOfBound is the panic raised when an arena is accessed outside of its bounds.
+	errIndexOutOfBound = errors.New("offset out of mapped region")
 )
 
 // newArena returns pointer to a mapped file. It takes a file location and mmaps it.
 // If file location does not exist, it creates a file of given size.
-func newArena(file string, size int) (*mmap.File, error) {
+func newArena(file string, size int) (*arena, error) {
 	fd, err := openOrCreateFile(file, int64(size))
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := mmap.NewSharedFileMmap(fd, 0, size, syscall.PROT_READ|syscall.PROT_WRITE)
+	data, err := syscall.Mmap(int(fd.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
 	if err != nil {
+		_ = fd.Close()
 		return nil, fmt.Errorf("error in mmaping a file :: %w", err)
 	}
 
 	// We can close the file descriptor here.
 	if err := fd.Close(); err != nil {
+		_ = syscall.Munmap(data)
 		return nil, fmt.Errorf("error in closing the fd :: %w", err)
 	}
 
-	return m, nil
+	return &arena{data: data}, nil
 }
 
 // openOrCreateFile opens the file if it exists,
@@ -50,3 +61,104 @@ func openOrCreateFile(file string, size int64) (*os.File, error) {
 
 	return fd, nil
 }
+
+// arena is a memory mapped arena file of a bigqueue. The file is mapped once, the
+// accessors below read and write the mapped memory, which is also exposed as a view
+// that allows accessing the data without copying it.
+type arena struct {
+	data   []byte
+	dirty  bool
+	pinned bool // pinned arenas are not evicted from memory
+}
+
+// boundaryChecks panics if the arena is unmapped or numBytes cannot
+// be read or written in the arena starting at given offset.
+func (a *arena) boundaryChecks(offset, numBytes int64) {
+	if a.data == nil {
+		panic(errUnmappedMemory)
+	} else if offset+numBytes > int64(len(a.data)) || offset < 0 {
+		panic(errIndexOutOfBound)
+	}
+}
+
+// ReadAt copies data to dest from the arena starting at given offset
+// and returns the number of bytes copied. err is always nil.
+func (a *arena) ReadAt(dest []byte, offset int64) (int, error) {
+	a.boundaryChecks(offset, 1)
+	return copy(dest, a.data[offset:]), nil
+}
+
+// WriteAt copies data from src to the arena starting at given offset
+// and returns the number of bytes copied. err is always nil.
+func (a *arena) WriteAt(src []byte, offset int64) (int, error) {
+	a.boundaryChecks(offset, 1)
+	a.dirty = true
+	return copy(a.data[offset:], src), nil
+}
+
+// ReadStringAt copies data to dest from the arena starting at given offset until the
+// end of the arena, the spare capacity of dest or maxLength, whichever comes first.
+func (a *arena) ReadStringAt(dest *strings.Builder, offset, maxLength int64) int {
+	a.boundaryChecks(offset, 1)
+
+	end := offset + min(int64(len(a.data))-offset, int64(dest.Cap()-dest.Len()), maxLength)
+	n, _ := dest.Write(a.data[offset:end])
+	return n
+}
+
+// WriteStringAt copies src to the arena starting at given
+// offset and returns the number of bytes copied.
+func (a *arena) WriteStringAt(src string, offset int64) int {
+	a.boundaryChecks(offset, 1)
+	a.dirty = true
+	return copy(a.data[offset:], src)
+}
+
+// ReadUint64At reads uint64 from offset.
+func (a *arena) ReadUint64At(offset int64) uint64 {
+	a.boundaryChecks(offset, cInt64Size)
+	return binary.LittleEndian.Uint64(a.data[offset:])
+}
+
+// WriteUint64At writes num at offset.
+func (a *arena) WriteUint64At(num uint64, offset int64) {
+	a.boundaryChecks(offset, cInt64Size)
+	a.dirty = true
+	binary.LittleEndian.PutUint64(a.data[offset:], num)
+}
+
+// view returns the whole arena as a slice of bytes aliasing the mapped file.
+// The slice is only valid until the arena is unmapped.
+func (a *arena) view() []byte {
+	return a.data
+}
+
+// markDirty marks the arena as modified so that it is synced upon the next flush.
+// Writes through the view are not tracked, hence, they need to be recorded explicitly.
+func (a *arena) markDirty() {
+	a.dirty = true
+}
+
+// Flush syncs the arena to disk. It only makes
+// a syscall if the arena is modified since the last flush.
+func (a *arena) Flush(flags int) error {
+	if !a.dirty {
+		return nil
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC,
+		uintptr(unsafe.Pointer(&a.data[0])), uintptr(len(a.data)), uintptr(flags))
+	if errno != 0 {
+		return errno
+	}
+
+	a.dirty = false
+	return nil
+}
+
+// Unmap unmaps the arena file. The arena must not be accessed afterwards.
+func (a *arena) Unmap() error {
+	err := syscall.Munmap(a.data)
+	a.data = nil
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -120,3 +121,38 @@ func TestNewArenaNoFolder(t *testing.T) {
 		t.Fatalf("expected file not exists error, returned: %v", err)
 	}
 }
+
+func TestArenaView(t *testing.T) {
+	t.Parallel()
+
+	aa, err := newArena(path.Join(t.TempDir(), "aa.dat"), 100)
+	if err != nil {
+		t.Fatalf("error in creating new arena: %v", err)
+	}
+	defer func() {
+		if err := aa.Unmap(); err != nil {
+			t.Fatalf("error occurred while unmapping: %v", err)
+		}
+	}()
+
+	// the view and the accessors share the same mapping
+	if _, err := aa.WriteAt([]byte("abc"), 10); err != nil {
+		t.Fatalf("error in writing to arena: %v", err)
+	}
+	if string(aa.view()[10:13]) != "abc" {
+		t.Fatalf("write should be visible in the view, actual: %q", aa.view()[10:13])
+	}
+	copy(aa.view()[20:], "xyz")
+	buf := make([]byte, 3)
+	if _, err := aa.ReadAt(buf, 20); err != nil || string(buf) != "xyz" {
+		t.Fatalf("write through the view should be visible, actual: %q :: %v", buf, err)
+	}
+
+	if err := aa.Flush(syscall.MS_SYNC); err != nil || aa.dirty {
+		t.Fatalf("error in flushing arena: %v", err)
+	}
+	aa.markDirty()
+	if err := aa.Flush(syscall.MS_SYNC); err != nil || aa.dirty {
+		t.Fatalf("error in flushing arena: %v", err)
+	}
+}
@@ -5,8 +5,6 @@ import (
 	"path"
 	"strconv"
 	"syscall"
-
-	"github.com/grandecola/mmap"
 )
 
 const (
@@ -19,7 +17,7 @@ type arenaManager struct {
 	conf     *bqConfig
 	md       *metadata
 	baseAid  int
-	arenas   []*mmap.File
+	arenas   []*arena
 	inMem    int
 	fullPath []byte
 }
@@ -30,7 +28,7 @@ func newArenaManager(dir string, conf *bqConfig, md *metadata) (*arenaManager, e
 	tailAid, _ := md.getTail()
 
 	numArenas := tailAid + 1 - headAid
-	arenas := make([]*mmap.File, numArenas)
+	arenas := make([]*arena, numArenas)
 	am := &arenaManager{
 		dir:     path.Clean(dir),
 		conf:    conf,
@@ -48,7 +46,7 @@ func newArenaManager(dir string, conf *bqConfig, md *metadata) (*arenaManager, e
 }
 
 // getArena returns arena for a given arena ID
-func (m *arenaManager) getArena(aid int) (*mmap.File, error) {
+func (m *arenaManager) getArena(aid int) (*arena, error) {
 	relAid := aid - m.baseAid
 	if relAid == len(m.arenas) {
 		m.arenas = append(m.arenas, nil)
@@ -87,6 +85,7 @@ func (m *arenaManager) ensureEnoughMem() error {
 	// Start evicting from the arena just before the last arena that we have.
 	// If message size > arena size, last arena may not always be the tail arena.
 	// We always ensure that head and tail arenas are not evicted from memory.
+	// Arenas pinned by an open reservation are not evicted either.
 	// Simply iterate from the last arena until enough memory is
 	// available for a new arena to be loaded into memory
 	tailAid, _ := m.md.getTail()
@@ -102,6 +101,10 @@ func (m *arenaManager) ensureEnoughMem() error {
 			continue
 		}
 
+		if aa := m.arenas[curAid-m.baseAid]; aa != nil && aa.pinned {
+			continue
+		}
+
 		if err := m.unloadArena(curAid); err != nil {
 			return err
 		}
@@ -120,7 +123,8 @@ func (m *arenaManager) loadArena(aid int) error {
 	m.fullPath = append(m.fullPath, '/')
 	m.fullPath = strconv.AppendInt(m.fullPath, int64(aid), 10)
 	m.fullPath = append(m.fullPath, cArenaFileSuffix...)
-	aa, err := newArena(string(m.fullPath), m.conf.arenaSize)
+	file := string(m.fullPath)
+	aa, err := newArena(file, m.conf.arenaSize)
 	if err != nil {
 		return err
 	}
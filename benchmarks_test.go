@@ -130,6 +130,42 @@ func BenchmarkEnqueue(b *testing.B) {
 	}
 }
 
+func BenchmarkEnqueueBatch(b *testing.B) {
+	for _, param := range getBenchParams() {
+		b.Run(fmt.Sprintf("ArenaSize-%s/MessageSize-%s/MaxMem-%s", param.arenaSizeString,
+			param.messageSizeString, param.maxInMemArenaString), func(b *testing.B) {
+
+			dir := path.Join(os.TempDir(), "testdir")
+			createBenchDir(b, dir)
+
+			bq, err := NewMmapQueue(dir, SetArenaSize(param.arenaSize), SetPeriodicFlushOps(0),
+				SetMaxInMemArenas(param.maxInMemArenaCount), SetPeriodicFlushDuration(0))
+			if err != nil {
+				b.Fatalf("unable to create bigqueue: %v", err)
+			}
+
+			batch := make([][]byte, 16)
+			for i := range batch {
+				batch[i] = param.message
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if err := bq.EnqueueBatch(batch); err != nil {
+					b.Fatalf("unable to enqueue: %v", err)
+				}
+			}
+
+			b.StopTimer()
+			if err := bq.Close(); err != nil {
+				b.Fatalf("unable to close bq: %v", err)
+			}
+			removeBenchDir(b, dir)
+		})
+	}
+}
+
 func BenchmarkEnqueueString(b *testing.B) {
 	for _, param := range getBenchParams() {
 		b.Run(fmt.Sprintf("ArenaSize-%s/MessageSize-%s/MaxMem-%s", param.arenaSizeString,
@@ -199,6 +235,44 @@ func BenchmarkDequeue(b *testing.B) {
 	}
 }
 
+func BenchmarkDequeueInto(b *testing.B) {
+	for _, param := range getBenchParams() {
+		b.Run(fmt.Sprintf("ArenaSize-%s/MessageSize-%s/MaxMem-%s", param.arenaSizeString,
+			param.messageSizeString, param.maxInMemArenaString), func(b *testing.B) {
+
+			dir := path.Join(os.TempDir(), "testdir")
+			createBenchDir(b, dir)
+
+			bq, err := NewMmapQueue(dir, SetArenaSize(param.arenaSize), SetPeriodicFlushDuration(0),
+				SetMaxInMemArenas(param.maxInMemArenaCount), SetPeriodicFlushOps(0))
+			if err != nil {
+				b.Fatalf("unable to create bigqueue: %v", err)
+			}
+
+			for range b.N {
+				if err := bq.Enqueue(param.message); err != nil {
+					b.Fatalf("unable to enqueue: %v", err)
+				}
+			}
+
+			buf := make([]byte, len(param.message))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if _, err := bq.DequeueInto(buf); err != nil {
+					b.Fatalf("unable to dequeue: %v", err)
+				}
+			}
+
+			b.StopTimer()
+			if err := bq.Close(); err != nil {
+				b.Fatalf("unable to close bq: %v", err)
+			}
+			removeBenchDir(b, dir)
+		})
+	}
+}
+
 func BenchmarkDequeueString(b *testing.B) {
 	for _, param := range getBenchParams() {
 		b.Run(fmt.Sprintf("ArenaSize-%s/MessageSize-%s/MaxMem-%s", param.arenaSizeString,
@@ -1,8 +1,11 @@
 package bigqueue
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -18,28 +21,42 @@ var (
 	// doesn't match with desired arena size.
 	ErrInvalidArenaSize = errors.New("mismatch in arena size")
 	// ErrDifferentQueues is returned when caller wants to copy
-	// offsets from a consumer from a different queue.
+	// offsets from a consumer from a different queue, identified by queue ID.
 	ErrDifferentQueues = errors.New("consumers from different queues")
+	// ErrQueueClosed is returned when an operation is waiting
+	// on the queue or is performed after the queue is closed.
+	ErrQueueClosed = errors.New("queue is closed")
 )
 
 // MmapQueue implements Queue interface.
 type MmapQueue struct {
+	id        [16]byte
+	dir       string
 	conf      *bqConfig
 	am        *arenaManager
 	md        *metadata
-	dc        int64 // default consumer
+	ti        *timeIndex
+	dq        *delayQueue               // nil until an element is delayed
+	inf       *inflight                 // nil until an element is received
+	cursors   map[int64]*cursor         // in-memory heads of consumers that commit manually
+	skipped   map[int64][]skippedRecord // expired records skipped ahead of the heads of consumers
+	dc        int64                     // default consumer
 	mutOps    int64
 	lastFlush time.Time
+	expired   uint64 // number of expired records dropped since the queue was opened
 
-	lock  sync.Mutex // protects bigqueue
-	drain chan struct{}
-	quit  chan struct{}
-	wg    sync.WaitGroup
+	wlock    sync.Mutex // serializes writes, held while a MessageWriter is open
+	lock     sync.Mutex // protects bigqueue
+	drain    chan struct{}
+	quit     chan struct{}
+	enqueued chan struct{} // closed upon next enqueue, nil when nobody waits
+	wg       sync.WaitGroup
 
 	br bytesReader
 	sr stringReader
 	bw bytesWriter
 	sw stringWriter
+	vw vecWriter
 }
 
 // NewMmapQueue constructs a new persistent queue.
@@ -88,14 +105,53 @@ func NewMmapQueue(dir string, opts ...Option) (*MmapQueue, error) {
 		return nil, fmt.Errorf("error in adding default consumer :: %w", err)
 	}
 
+	tailAid, tailOffset := md.getTail()
+	ti, err := newTimeIndex(dir, tailAid, tailOffset)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if !complete {
+			_ = ti.close()
+		}
+	}()
+
 	bq := &MmapQueue{
-		conf:  conf,
-		am:    am,
-		md:    md,
-		dc:    dc,
-		drain: make(chan struct{}, 1),
-		quit:  make(chan struct{}),
+		id:      md.getID(),
+		dir:     dir,
+		conf:    conf,
+		am:      am,
+		md:      md,
+		ti:      ti,
+		dc:      dc,
+		drain:   make(chan struct{}, 1),
+		cursors: make(map[int64]*cursor),
+		skipped: make(map[int64][]skippedRecord),
+		quit:    make(chan struct{}),
 	}
+	// the in-flight file is only created once an element is received.
+	if _, err := os.Stat(filepath.Join(dir, cInflightFileName)); err == nil {
+		inf, err := newInflight(dir, tailAid, tailOffset)
+		if err != nil {
+			return nil, err
+		}
+		bq.inf = inf
+		defer func() {
+			if !complete {
+				_ = inf.close()
+			}
+		}()
+	}
+
+	// the delay queue is only created once an element is delayed.
+	if _, err := os.Stat(filepath.Join(dir, cDelayedDir)); err == nil {
+		dq, err := newDelayQueue(filepath.Join(dir, cDelayedDir), conf)
+		if err != nil {
+			return nil, err
+		}
+		bq.startPromoting(dq)
+	}
+
 	bq.wg.Add(1)
 	go bq.periodicFlush()
 
@@ -104,7 +160,12 @@ func NewMmapQueue(dir string, opts ...Option) (*MmapQueue, error) {
 }
 
 // NewConsumer creates a new consumer or finds an existing one with same name.
-func (q *MmapQueue) NewConsumer(name string) (*Consumer, error) {
+func (q *MmapQueue) NewConsumer(name string, opts ...ConsumerOption) (*Consumer, error) {
+	conf := &consumerConfig{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
@@ -113,33 +174,104 @@ func (q *MmapQueue) NewConsumer(name string) (*Consumer, error) {
 		return nil, err
 	}
 
-	return &Consumer{mq: q, base: base}, nil
+	// the cursor is shared by all the handles of the consumer.
+	if _, ok := q.cursors[base]; conf.manualCommit && !ok {
+		aid, offset := q.md.getConsumerHead(base)
+		q.cursors[base] = &cursor{aid: aid, offset: offset, seq: q.md.getConsumerSeq(base)}
+	}
+
+	return &Consumer{mq: q, name: name, base: base}, nil
 }
 
 // FromConsumer creates a new consumer or finds an existing one with same name.
-// It also copies the offsets from the given consumer to this consumer.
+// It also copies the offsets from the given consumer to this consumer. The given
+// consumer may belong to another open handle of the same queue, e.g. a restored
+// copy of the queue, as long as the queue ID matches and its offsets refer to an
+// element of this queue. It may also belong to a handle of this queue that was
+// closed before the queue was reopened, in which case the persisted offsets of the
+// consumer are copied. ErrDifferentQueues is returned in all the other cases.
 func (q *MmapQueue) FromConsumer(name string, from *Consumer) (*Consumer, error) {
-	if q != from.mq {
+	if q.id != from.mq.id {
 		return nil, ErrDifferentQueues
 	}
 
+	// offsets of a consumer of another handle are read from that handle.
+	var head *Position
+	if from.mq != q {
+		pos, err := from.mq.position(from.base)
+		switch {
+		case err == nil:
+			head = &pos
+		case !errors.Is(err, ErrQueueClosed) || !sameFile(q.md.file, from.mq.md.file):
+			return nil, ErrDifferentQueues
+		}
+	}
+
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
+	if head == nil {
+		fromBase, ok := q.md.co[from.name]
+		if !ok {
+			return nil, ErrDifferentQueues
+		}
+
+		aid, offset := q.getConsumerHead(fromBase)
+		head = &Position{id: q.id, aid: aid, offset: offset, seq: q.getConsumerSeq(fromBase)}
+	} else if err := q.verifyPosition(*head); err != nil {
+		return nil, ErrDifferentQueues
+	}
+
 	base, err := q.md.getConsumer(name)
 	if err != nil {
 		return nil, err
 	}
 
 	// update offsets to given consumer
-	aid, pos := q.md.getConsumerHead(from.base)
-	q.md.putConsumerHead(base, aid, pos)
+	q.putConsumerHead(base, head.aid, head.offset)
+	q.putConsumerSeq(base, head.seq)
+
+	return &Consumer{mq: q, name: name, base: base}, nil
+}
+
+// sameFile returns true if both the paths refer to the same existing file.
+func sameFile(path1, path2 string) bool {
+	info1, err := os.Stat(path1)
+	if err != nil {
+		return false
+	}
+
+	info2, err := os.Stat(path2)
+	if err != nil {
+		return false
+	}
 
-	return &Consumer{mq: q, base: base}, nil
+	return os.SameFile(info1, info2)
+}
+
+// ID returns the ID of the queue. The ID is generated when the queue is
+// created and is persisted in the metadata, hence, it stays the same when
+// the queue is reopened, or restored from a copy of the queue directory.
+func (q *MmapQueue) ID() string {
+	id := q.id
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
 }
 
 // Close will close metadata and arena manager.
 func (q *MmapQueue) Close() error {
+	// open reservations and message writers hold the write lock and alias the
+	// memory mapped arenas, Close waits for them to be committed or aborted
+	// before anything is unmapped. Background go routines never wait for the
+	// write lock, see promoteDelayed, hence, they can still be stopped below.
+	q.wlock.Lock()
+	defer q.wlock.Unlock()
+
+	// signal all the waiting and background go routines to stop. quit is closed
+	// with the lock held so that waiters observe it consistently with the state.
+	q.lock.Lock()
+	close(q.quit)
+	q.lock.Unlock()
+
 	// wait for background go routines to finish.
 	// we need to acquire lock afterwards so that we avoid a livelock
 	// between periodic flush goroutine and Close() function.
@@ -148,7 +280,6 @@ func (q *MmapQueue) Close() error {
 	//     -> Close() waiting for periodic flush goroutine to stop ->
 	//     -> Periodic flush goroutine is trying to acquire the lock ->
 	//     -> Close() to release the lock
-	close(q.quit)
 	q.wg.Wait()
 
 	q.lock.Lock()
@@ -163,6 +294,22 @@ func (q *MmapQueue) Close() error {
 		retErr = err
 	}
 
+	if err := q.ti.close(); err != nil {
+		retErr = err
+	}
+
+	if q.dq != nil {
+		if err := q.dq.close(); err != nil {
+			retErr = err
+		}
+	}
+
+	if q.inf != nil {
+		if err := q.inf.close(); err != nil {
+			retErr = err
+		}
+	}
+
 	return retErr
 }
 
@@ -179,11 +326,71 @@ func (q *MmapQueue) Flush() error {
 		return err
 	}
 
+	if err := q.ti.flush(); err != nil {
+		return err
+	}
+
+	if q.inf != nil {
+		if err := q.inf.flush(); err != nil {
+			return err
+		}
+	}
+
+	// elements are only removed from the delay queue after
+	// they are moved to the queue, hence, it is flushed last.
+	if q.dq != nil {
+		if err := q.dq.flush(); err != nil {
+			return err
+		}
+	}
+
 	q.mutOps = 0
 	q.lastFlush = time.Now()
 	return nil
 }
 
+// isClosed returns true once Close has been called on the queue.
+func (q *MmapQueue) isClosed() bool {
+	select {
+	case <-q.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueueNotifier returns a channel that is closed upon the next enqueue, or
+// when the head of a consumer moves backwards and elements become available again.
+func (q *MmapQueue) enqueueNotifier() <-chan struct{} {
+	if q.enqueued == nil {
+		q.enqueued = make(chan struct{})
+	}
+
+	return q.enqueued
+}
+
+// notifyEnqueue wakes up all the go routines waiting for an enqueue. It is also
+// called whenever elements may become available again, e.g. upon seeking backwards.
+func (q *MmapQueue) notifyEnqueue() {
+	if q.enqueued != nil {
+		close(q.enqueued)
+		q.enqueued = nil
+	}
+}
+
+// waitFor blocks until the given channel is closed. It returns ctx.Err()
+// if the context is done and ErrQueueClosed if the queue is closed first.
+func (q *MmapQueue) waitFor(ctx context.Context, ch <-chan struct{}) error {
+	select {
+	case <-ch:
+		return nil
+	case <-q.quit:
+		return ErrQueueClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (q *MmapQueue) incrMutOps() {
 	if q.conf.flushMutOps <= 0 {
 		return
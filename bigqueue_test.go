@@ -2,11 +2,15 @@ package bigqueue
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -88,13 +92,13 @@ func TestDequeue(t *testing.T) {
 	}
 }
 
-func TestEnqueueSmallMessage(t *testing.T) {
+func TestPeek(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
 	bq, err := NewMmapQueue(testDir)
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
 	defer func() {
 		if err := bq.Close(); err != nil {
@@ -102,29 +106,68 @@ func TestEnqueueSmallMessage(t *testing.T) {
 		}
 	}()
 
+	if msg, err := bq.Peek(); err != ErrEmptyQueue || msg != nil {
+		t.Fatalf("Peek should return empty queue error, returned: %v", err)
+	}
+	if _, err := bq.PeekString(); err != ErrEmptyQueue {
+		t.Fatalf("PeekString should return empty queue error, returned: %v", err)
+	}
+
 	msg := []byte("abcdefghij")
 	if err := bq.Enqueue(msg); err != nil {
 		t.Fatalf("enqueue failed :: %v", err)
 	}
 
-	if bq.IsEmpty() {
-		t.Fatalf("BigQueue should not be empty")
+	for range 2 {
+		if headMsg, err := bq.Peek(); err != nil {
+			t.Fatalf("Peek failed :: %v", err)
+		} else if !bytes.Equal(msg, headMsg) {
+			t.Fatalf("messages don't match :: expected %s, actual: %s", string(msg), string(headMsg))
+		}
+		if headMsg, err := bq.PeekString(); err != nil {
+			t.Fatalf("PeekString failed :: %v", err)
+		} else if string(msg) != headMsg {
+			t.Fatalf("messages don't match :: expected %s, actual: %s", string(msg), headMsg)
+		}
 	}
 
-	if poppedMsg, err := bq.Dequeue(); err != nil {
-		t.Fatalf("unable to dequeue :: %v", err)
-	} else if !bytes.Equal(msg, poppedMsg) {
-		t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	if headMsg, err := c.Peek(); err != nil {
+		t.Fatalf("Peek failed :: %v", err)
+	} else if !bytes.Equal(msg, headMsg) {
+		t.Fatalf("messages don't match :: expected %s, actual: %s", string(msg), string(headMsg))
+	}
+
+	if headMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("Dequeue failed :: %v", err)
+	} else if !bytes.Equal(msg, headMsg) {
+		t.Fatalf("messages don't match :: expected %s, actual: %s", string(msg), string(headMsg))
+	}
+
+	if !bq.IsEmpty() {
+		t.Fatalf("IsEmpty should return true")
+	}
+	if c.IsEmpty() {
+		t.Fatalf("IsEmpty should return false for consumer")
+	}
+	if headMsg, err := c.PeekString(); err != nil {
+		t.Fatalf("PeekString failed :: %v", err)
+	} else if string(msg) != headMsg {
+		t.Fatalf("messages don't match :: expected %s, actual: %s", string(msg), headMsg)
 	}
 }
 
-func TestEnqueueLargeMessage(t *testing.T) {
+func TestPeekN(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
-	bq, err := NewMmapQueue(testDir)
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize), SetMaxInMemArenas(3))
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
 	defer func() {
 		if err := bq.Close(); err != nil {
@@ -132,33 +175,65 @@ func TestEnqueueLargeMessage(t *testing.T) {
 		}
 	}()
 
-	msg := make([]byte, 0, cDefaultArenaSize-8)
-	for range cDefaultArenaSize - 8 {
-		m := []byte("a")
-		msg = append(msg, m...)
+	if _, err := bq.PeekN(3); err != ErrEmptyQueue {
+		t.Fatalf("PeekN should return empty queue error, returned: %v", err)
 	}
-	if err := bq.Enqueue(msg); err != nil {
-		t.Fatalf("enqueue failed :: %v", err)
+
+	// messages span across multiple arenas
+	msgs := make([][]byte, 0, 6)
+	for i := range 6 {
+		msg := bytes.Repeat([]byte(strconv.Itoa(i)), arenaSize*2/3)
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+		msgs = append(msgs, msg)
 	}
 
-	if deQueuedMsg, err := bq.Dequeue(); err != nil {
+	if _, err := bq.Dequeue(); err != nil {
 		t.Fatalf("Dequeue failed :: %v", err)
-	} else if !bytes.Equal(deQueuedMsg, msg) {
-		t.Fatalf("dequeued and enqueued messages are not equal")
 	}
 
-	if !bq.IsEmpty() {
-		t.Fatalf("IsEmpty should return true")
+	peeked, err := bq.PeekN(3)
+	if err != nil {
+		t.Fatalf("PeekN failed :: %v", err)
+	}
+	if len(peeked) != 3 {
+		t.Fatalf("PeekN should return 3 elements, actual: %v", len(peeked))
+	}
+	for i, msg := range peeked {
+		if !bytes.Equal(msgs[i+1], msg) {
+			t.Fatalf("messages don't match for element %d", i)
+		}
+	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	peeked, err = c.PeekN(10)
+	if err != nil {
+		t.Fatalf("PeekN failed :: %v", err)
+	}
+	if len(peeked) != len(msgs) {
+		t.Fatalf("PeekN should return %d elements, actual: %v", len(msgs), len(peeked))
+	}
+
+	for _, msg := range msgs[1:] {
+		if headMsg, err := bq.Dequeue(); err != nil {
+			t.Fatalf("Dequeue failed :: %v", err)
+		} else if !bytes.Equal(msg, headMsg) {
+			t.Fatalf("messages don't match after PeekN")
+		}
 	}
 }
 
-func TestEnqueueOverlapLength(t *testing.T) {
+func TestDequeueWait(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
 	bq, err := NewMmapQueue(testDir)
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
 	defer func() {
 		if err := bq.Close(); err != nil {
@@ -166,96 +241,89 @@ func TestEnqueueOverlapLength(t *testing.T) {
 		}
 	}()
 
-	msg1 := make([]byte, 0, cDefaultArenaSize-12)
-	for range cDefaultArenaSize - 12 {
-		m := []byte("a")
-		msg1 = append(msg1, m...)
-	}
-	if err := bq.Enqueue(msg1); err != nil {
-		t.Fatalf("enqueue failed :: %v", err)
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
 	}
 
-	msg2 := make([]byte, 0, cDefaultArenaSize-4)
-	for range cDefaultArenaSize - 4 {
-		m := []byte("a")
-		msg2 = append(msg2, m...)
-	}
-	if err := bq.Enqueue(msg2); err != nil {
-		t.Fatalf("enqueue failed :: %v", err)
-	}
+	msg := []byte("abcdefghij")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = bq.Enqueue(msg)
+	}()
 
-	if dequeueMsg, err := bq.Dequeue(); err != nil {
-		t.Fatalf("Dequeue failed :: %v", err)
-	} else if !bytes.Equal(dequeueMsg, msg1) {
-		t.Fatalf("dequeued and enqeued messages are not equal")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if headMsg, err := bq.DequeueWait(ctx); err != nil {
+		t.Fatalf("DequeueWait failed :: %v", err)
+	} else if !bytes.Equal(msg, headMsg) {
+		t.Fatalf("messages don't match :: expected %s, actual: %s", string(msg), string(headMsg))
 	}
 
-	if dequeueMsg, err := bq.Dequeue(); err != nil {
-		t.Fatalf("Dequeue failed :: %v", err)
-	} else if !bytes.Equal(dequeueMsg, msg2) {
-		t.Fatalf("dequeued and enqeued messages are not equal")
+	// element is already present for the consumer
+	if headMsg, err := c.DequeueWait(ctx); err != nil {
+		t.Fatalf("DequeueWait failed :: %v", err)
+	} else if !bytes.Equal(msg, headMsg) {
+		t.Fatalf("messages don't match :: expected %s, actual: %s", string(msg), string(headMsg))
 	}
 
-	if !bq.IsEmpty() {
-		t.Fatalf("queue should be empty")
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer timeoutCancel()
+	if _, err := c.DequeueWait(timeoutCtx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded error, returned: %v", err)
 	}
 }
 
-func TestEnqueueLargeNumberOfMessages(t *testing.T) {
+func TestDequeueWaitClose(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
 	bq, err := NewMmapQueue(testDir)
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %s", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
-	defer func() {
-		if err := bq.Close(); err != nil {
-			t.Fatalf("error in closing bigqueue :: %v", err)
-		}
-	}()
 
-	numMessages := 10
-	lengths := make([]int, 0, numMessages)
-	alphabets := "abcdefghijklmnopqrstuvwxyz"
-	for range numMessages {
-		msgLen := rand.Intn(cDefaultArenaSize) + cDefaultArenaSize
-		lengths = append(lengths, msgLen)
-		msg := make([]byte, 0)
-		for {
-			if msgLen > len(alphabets) {
-				msg = append(msg, []byte(alphabets)...)
-				msgLen -= len(alphabets)
-			} else {
-				msg = append(msg, []byte(alphabets[0:msgLen])...)
-				break
-			}
-		}
-		if err := bq.Enqueue(msg); err != nil {
-			t.Fatalf("unable to enqueue message :: %v", err)
-		}
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
 	}
 
-	for i := range numMessages {
-		if msg, err := bq.Dequeue(); err != nil {
-			t.Fatalf("unable to dequeue message :: %v", err)
-		} else if len(msg) != lengths[i] {
-			t.Fatalf("enqueued and dequeued lengths don't match for msg no %d", i)
+	errChan := make(chan error, 4)
+	for range 2 {
+		go func() {
+			_, err := bq.DequeueWait(context.Background())
+			errChan <- err
+		}()
+		go func() {
+			_, err := c.DequeueWait(context.Background())
+			errChan <- err
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	for range 4 {
+		if err := <-errChan; err != ErrQueueClosed {
+			t.Fatalf("expected queue closed error, returned: %v", err)
 		}
 	}
 
-	if !bq.IsEmpty() {
-		t.Fatalf("queue should be empty")
+	if _, err := bq.DequeueWait(context.Background()); err != ErrQueueClosed {
+		t.Fatalf("expected queue closed error, returned: %v", err)
 	}
 }
 
-func TestEnqueueZeroLengthMessage(t *testing.T) {
+func TestDequeueInto(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
-	bq, err := NewMmapQueue(testDir)
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
 	defer func() {
 		if err := bq.Close(); err != nil {
@@ -263,34 +331,51 @@ func TestEnqueueZeroLengthMessage(t *testing.T) {
 		}
 	}()
 
-	emptyMsg := make([]byte, 0)
-	if err := bq.Enqueue(emptyMsg); err != nil {
-		t.Fatalf("unable to enqueue empty message :: %v", err)
+	buf := make([]byte, 16, arenaSize*2)
+	if _, err := bq.DequeueInto(buf); err != ErrEmptyQueue {
+		t.Fatalf("DequeueInto should return empty queue error, returned: %v", err)
 	}
 
-	if bq.IsEmpty() {
-		t.Fatalf("IsEmpty should return false if empty message is present in queue")
+	// message spans across arenas
+	msg := bytes.Repeat([]byte("a"), arenaSize+100)
+	if err := bq.Enqueue(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
 	}
 
-	if deQueuedMsg, err := bq.Dequeue(); err != nil {
-		t.Fatalf("unable to dequeue empty message")
-	} else if !bytes.Equal(deQueuedMsg, emptyMsg) {
-		t.Fatalf("dequeued and enqueued messages are not equal")
+	var tooSmall *BufferTooSmallError
+	if _, err := bq.DequeueInto(make([]byte, 10)); !errors.Is(err, ErrBufferTooSmall) {
+		t.Fatalf("expected buffer too small error, returned: %v", err)
+	} else if !errors.As(err, &tooSmall) || tooSmall.Len != len(msg) {
+		t.Fatalf("expected needed length %d, returned: %v", len(msg), err)
 	}
 
-	if !bq.IsEmpty() {
-		t.Fatalf("queue should be empty now")
+	headMsg, err := bq.DequeueInto(buf)
+	if err != nil {
+		t.Fatalf("DequeueInto failed :: %v", err)
+	} else if !bytes.Equal(msg, headMsg) {
+		t.Fatalf("messages don't match")
+	} else if &headMsg[0] != &buf[:1][0] {
+		t.Fatalf("DequeueInto should reuse the given buffer")
+	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	if headMsg, err := c.DequeueInto(buf); err != nil {
+		t.Fatalf("DequeueInto failed :: %v", err)
+	} else if !bytes.Equal(msg, headMsg) {
+		t.Fatalf("messages don't match")
 	}
 }
 
-func TestEnqueueWhenMessageLengthFits(t *testing.T) {
+func TestAppendDequeue(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
-	arenaSize := 4 * 1024
-	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	bq, err := NewMmapQueue(testDir)
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
 	defer func() {
 		if err := bq.Close(); err != nil {
@@ -298,80 +383,82 @@ func TestEnqueueWhenMessageLengthFits(t *testing.T) {
 		}
 	}()
 
-	msg1 := bytes.Repeat([]byte("a"), arenaSize-16)
-	if err := bq.Enqueue(msg1); err != nil {
-		t.Fatalf("unable to enqueue msg1: %s", err)
+	prefix := []byte("prefix:")
+	if dst, err := bq.AppendDequeue(prefix); err != ErrEmptyQueue || !bytes.Equal(dst, prefix) {
+		t.Fatalf("AppendDequeue should return dst and empty queue error, returned: %v", err)
 	}
 
-	msg2 := bytes.Repeat([]byte("b"), 3*arenaSize)
-	if err := bq.Enqueue(msg2); err != nil {
-		t.Fatalf("unable to enqueue msg2: %s", err)
+	for _, msg := range []string{"elem1", "elem2"} {
+		if err := bq.EnqueueString(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
 	}
 
-	if _, err := bq.Dequeue(); err != nil {
-		t.Fatalf("unable to dequeue msg1: %s", err)
+	dst, err := bq.AppendDequeue(prefix)
+	if err != nil {
+		t.Fatalf("AppendDequeue failed :: %v", err)
 	}
-	if _, err := bq.Dequeue(); err != nil {
-		t.Fatalf("unable to dequeue msg2: %s", err)
+	dst, err = bq.AppendDequeue(dst)
+	if err != nil {
+		t.Fatalf("AppendDequeue failed :: %v", err)
+	}
+	if string(dst) != "prefix:elem1elem2" {
+		t.Fatalf("unexpected appended data: %s", string(dst))
 	}
-}
 
-func TestReadWriteCornerCases(t *testing.T) {
-	t.Parallel()
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	if dst, err := c.AppendDequeue(nil); err != nil {
+		t.Fatalf("AppendDequeue failed :: %v", err)
+	} else if string(dst) != "elem1" {
+		t.Fatalf("unexpected appended data: %s", string(dst))
+	}
+}
 
+//nolint:paralleltest // AllocsPerRun cannot be used in a parallel test
+func TestDequeueIntoNoAlloc(t *testing.T) {
 	testDir := t.TempDir()
-	arenaSize := 8 * 1024
-	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	bq, err := NewMmapQueue(testDir, SetPeriodicFlushOps(0), SetPeriodicFlushDuration(0))
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
-
-	for i := 1; i < 10; i++ {
-		msgLength := i*arenaSize/2 - 8
-		if i == 5 {
-			msgLength -= 8
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
 		}
-		msg := bytes.Repeat([]byte("a"), msgLength)
+	}()
+
+	msg := []byte("abcdefghij")
+	buf := make([]byte, 0, 128)
+	allocs := testing.AllocsPerRun(100, func() {
 		if err := bq.Enqueue(msg); err != nil {
 			t.Fatalf("enqueue failed :: %v", err)
 		}
-
-		if bq.IsEmpty() {
-			t.Fatalf("BigQueue should not be empty")
-		}
-
-		if err := bq.Close(); err != nil {
-			t.Fatalf("error in closing bigqueue :: %v", err)
-		}
-		if bqTemp, err := NewMmapQueue(testDir, SetArenaSize(arenaSize)); err != nil {
-			t.Fatalf("unable to get BigQueue: %v", err)
-		} else {
-			bq = bqTemp
+		if _, err := bq.DequeueInto(buf); err != nil {
+			t.Fatalf("DequeueInto failed :: %v", err)
 		}
-
-		if poppedMsg, err := bq.Dequeue(); err != nil {
-			t.Fatalf("unable to dequeue :: %v", err)
-		} else if !bytes.Equal(msg, poppedMsg) {
-			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
 		}
-
-		if !bq.IsEmpty() {
-			t.Fatalf("BigQueue should be empty")
+		if _, err := bq.AppendDequeue(buf); err != nil {
+			t.Fatalf("AppendDequeue failed :: %v", err)
 		}
-	}
-
-	if err := bq.Close(); err != nil {
-		t.Fatalf("error in closing bigqueue :: %v", err)
+	})
+	if allocs != 0 {
+		t.Fatalf("DequeueInto should not allocate, allocs: %v", allocs)
 	}
 }
 
-func TestArenaSize(t *testing.T) {
+func TestDequeueFunc(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
-	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
 	defer func() {
 		if err := bq.Close(); err != nil {
@@ -379,30 +466,74 @@ func TestArenaSize(t *testing.T) {
 		}
 	}()
 
-	msg := []byte("abcdefghij")
-	if err := bq.Enqueue(msg); err != nil {
-		t.Fatalf("enqueue failed :: %v", err)
+	if err := bq.DequeueFunc(func([]byte) error { return nil }); err != ErrEmptyQueue {
+		t.Fatalf("DequeueFunc should return empty queue error, returned: %v", err)
 	}
 
-	if bq.IsEmpty() {
-		t.Fatalf("BigQueue should not be empty")
+	// first message fits in an arena, second one spans across arenas
+	msgs := [][]byte{[]byte("abcdefghij"), bytes.Repeat([]byte("b"), arenaSize+100)}
+	for _, msg := range msgs {
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
 	}
 
-	if poppedMsg, err := bq.Dequeue(); err != nil {
-		t.Fatalf("unable to dequeue :: %v", err)
-	} else if !bytes.Equal(msg, poppedMsg) {
-		t.Fatalf("unequal length, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	errProcess := errors.New("process failed")
+	var first *byte
+	if err := bq.DequeueFunc(func(msg []byte) error {
+		first = &msg[0]
+		return errProcess
+	}); err != errProcess {
+		t.Fatalf("DequeueFunc should return error from callback, returned: %v", err)
+	}
+
+	// element stays in the queue and is not copied
+	if err := bq.DequeueFunc(func(msg []byte) error {
+		if !bytes.Equal(msgs[0], msg) {
+			return fmt.Errorf("unequal messages, eq: %s, dq: %s", string(msgs[0]), string(msg))
+		}
+		if &msg[0] != first {
+			return errors.New("element should alias the mapped arena")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("DequeueFunc failed :: %v", err)
+	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	checkFunc := func(exp []byte) func([]byte) error {
+		return func(msg []byte) error {
+			if !bytes.Equal(exp, msg) {
+				return errors.New("unequal messages")
+			}
+			return nil
+		}
+	}
+	if err := bq.DequeueFunc(checkFunc(msgs[1])); err != nil {
+		t.Fatalf("DequeueFunc failed :: %v", err)
+	}
+	for _, msg := range msgs {
+		if err := c.DequeueFunc(checkFunc(msg)); err != nil {
+			t.Fatalf("DequeueFunc failed :: %v", err)
+		}
+	}
+
+	if !bq.IsEmpty() || !c.IsEmpty() {
+		t.Fatalf("IsEmpty should return true")
 	}
 }
 
-func TestArenaSize2(t *testing.T) {
+func TestDequeueBatch(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
-	arenaSize := os.Getpagesize() * 2
+	arenaSize := 8 * 1024
 	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
 	defer func() {
 		if err := bq.Close(); err != nil {
@@ -410,34 +541,81 @@ func TestArenaSize2(t *testing.T) {
 		}
 	}()
 
-	msg := []byte("abcdefghij")
-	for range arenaSize / len(msg) * 4 {
+	if _, err := bq.DequeueBatch(10, 0); err != ErrEmptyQueue {
+		t.Fatalf("DequeueBatch should return empty queue error, returned: %v", err)
+	}
+
+	msgs := make([][]byte, 0, 10)
+	for i := range 10 {
+		msg := bytes.Repeat([]byte(strconv.Itoa(i)), 1000)
 		if err := bq.Enqueue(msg); err != nil {
 			t.Fatalf("enqueue failed :: %v", err)
 		}
+		msgs = append(msgs, msg)
 	}
 
-	if bq.IsEmpty() {
-		t.Fatalf("BigQueue should not be empty")
+	checkBatch := func(batch, exp [][]byte) {
+		t.Helper()
+		if len(batch) != len(exp) {
+			t.Fatalf("unexpected batch size, exp: %v, actual: %v", len(exp), len(batch))
+		}
+		for i := range batch {
+			if !bytes.Equal(batch[i], exp[i]) {
+				t.Fatalf("messages don't match for element %d", i)
+			}
+		}
 	}
 
-	for range arenaSize / len(msg) * 4 {
-		if poppedMsg, err := bq.Dequeue(); err != nil {
-			t.Fatalf("unable to dequeue :: %v", err)
-		} else if !bytes.Equal(msg, poppedMsg) {
-			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
-		}
+	// limited by number of messages
+	batch, err := bq.DequeueBatch(3, 0)
+	if err != nil {
+		t.Fatalf("DequeueBatch failed :: %v", err)
+	}
+	checkBatch(batch, msgs[:3])
+
+	// limited by number of bytes, spanning across arenas
+	batch, err = bq.DequeueBatch(100, 4500)
+	if err != nil {
+		t.Fatalf("DequeueBatch failed :: %v", err)
+	}
+	checkBatch(batch, msgs[3:7])
+
+	// first message is returned even if it exceeds max bytes
+	batch, err = bq.DequeueBatch(100, 10)
+	if err != nil {
+		t.Fatalf("DequeueBatch failed :: %v", err)
+	}
+	checkBatch(batch, msgs[7:8])
+
+	batch, err = bq.DequeueBatch(100, 0)
+	if err != nil {
+		t.Fatalf("DequeueBatch failed :: %v", err)
+	}
+	checkBatch(batch, msgs[8:])
+
+	if !bq.IsEmpty() {
+		t.Fatalf("IsEmpty should return true")
 	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	batch, err = c.DequeueBatch(len(msgs), 0)
+	if err != nil {
+		t.Fatalf("DequeueBatch failed :: %v", err)
+	}
+	checkBatch(batch, msgs)
 }
 
-func TestArenaSize3(t *testing.T) {
+func TestMessages(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
-	arenaSize := os.Getpagesize()
+	arenaSize := 8 * 1024
 	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
 	defer func() {
 		if err := bq.Close(); err != nil {
@@ -445,79 +623,83 @@ func TestArenaSize3(t *testing.T) {
 		}
 	}()
 
-	msg := []byte("abcdefgh")
-	for range arenaSize / len(msg) * 4 {
+	msgs := make([][]byte, 0, 10)
+	for i := range 10 {
+		msg := bytes.Repeat([]byte(strconv.Itoa(i)), 1000)
 		if err := bq.Enqueue(msg); err != nil {
 			t.Fatalf("enqueue failed :: %v", err)
 		}
+		msgs = append(msgs, msg)
 	}
 
-	if bq.IsEmpty() {
-		t.Fatalf("BigQueue should not be empty")
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
 	}
 
-	for range arenaSize / len(msg) * 4 {
-		if poppedMsg, err := bq.Dequeue(); err != nil {
-			t.Fatalf("unable to dequeue :: %v", err)
-		} else if !bytes.Equal(msg, poppedMsg) {
-			t.Fatalf("unequal length, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	// breaking early leaves the remaining elements in the queue
+	i := 0
+	for msg, err := range c.Messages(context.Background()) {
+		if err != nil {
+			t.Fatalf("Messages failed :: %v", err)
+		}
+		if !bytes.Equal(msg, msgs[i]) {
+			t.Fatalf("messages don't match for element %d", i)
+		}
+		i++
+		if i == 4 {
+			break
 		}
 	}
-}
-
-func TestArenaSizeFail(t *testing.T) {
-	t.Parallel()
-
-	testDir := t.TempDir()
-	_, err := NewMmapQueue(testDir, SetArenaSize(os.Getpagesize()/2))
-	if err != ErrTooSmallArenaSize {
-		t.Fatalf("expected error: %v, got: %v", ErrTooSmallArenaSize, err)
+	if msg, err := c.Peek(); err != nil {
+		t.Fatalf("Peek failed :: %v", err)
+	} else if !bytes.Equal(msg, msgs[4]) {
+		t.Fatalf("head should be at element 4 after break")
 	}
-}
-
-func TestArenaSizeFail2(t *testing.T) {
-	t.Parallel()
 
-	testDir := t.TempDir()
-	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
-	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+	// context is checked before every element
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err := range c.Messages(ctx) {
+		if err != context.Canceled {
+			t.Fatalf("Messages should return context error, returned: %v", err)
+		}
 	}
 
-	msg := []byte("abcdefghij")
-	if err := bq.Enqueue(msg); err != nil {
-		t.Fatalf("enqueue failed :: %v", err)
+	for msg, err := range c.Messages(context.Background()) {
+		if err != nil {
+			t.Fatalf("Messages failed :: %v", err)
+		}
+		if !bytes.Equal(msg, msgs[i]) {
+			t.Fatalf("messages don't match for element %d", i)
+		}
+		i++
+	}
+	if i != len(msgs) || !c.IsEmpty() {
+		t.Fatalf("Messages should drain the queue, read: %d", i)
 	}
 
-	if err := bq.Close(); err != nil {
-		t.Fatalf("error in closing bigqueue :: %v", err)
+	// default consumer is not affected by other consumers
+	n := 0
+	for _, err := range bq.Messages(context.Background()) {
+		if err != nil {
+			t.Fatalf("Messages failed :: %v", err)
+		}
+		n++
 	}
-	if _, err := NewMmapQueue(testDir, SetArenaSize(6*1024)); err != ErrInvalidArenaSize {
-		t.Fatalf("expected invalid arena size error, got: %v", err)
+	if n != len(msgs) {
+		t.Fatalf("unexpected number of elements, exp: %d, actual: %d", len(msgs), n)
 	}
 }
 
-func TestArenaSizeNotMultiple(t *testing.T) {
+func TestNextLenBacklogBytes(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
-	bq, err := NewMmapQueue(testDir, SetArenaSize(5732))
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
-	}
-
-	msg := []byte("abcdefghij")
-	if err := bq.Enqueue(msg); err != nil {
-		t.Fatalf("enqueue failed :: %v", err)
-	}
-
-	if err := bq.Close(); err != nil {
-		t.Fatalf("error in closing bigqueue :: %v", err)
-	}
-	if tempBq, err := NewMmapQueue(testDir, SetArenaSize(5732)); err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
-	} else {
-		bq = tempBq
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
 	defer func() {
 		if err := bq.Close(); err != nil {
@@ -525,68 +707,106 @@ func TestArenaSizeNotMultiple(t *testing.T) {
 		}
 	}()
 
-	if poppedMsg, err := bq.Dequeue(); err != nil {
-		t.Fatalf("unable to dequeue :: %v", err)
-	} else if !bytes.Equal(msg, poppedMsg) {
-		t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	if _, err := bq.NextLen(); err != ErrEmptyQueue {
+		t.Fatalf("NextLen should return empty queue error, returned: %v", err)
+	}
+	if n := bq.BacklogBytes(); n != 0 {
+		t.Fatalf("BacklogBytes should be 0 for empty queue, returned: %d", n)
 	}
-}
 
-func TestNewBigqueueNoFolder(t *testing.T) {
-	t.Parallel()
+	// messages span across arenas
+	msgLen := arenaSize / 3
+	for i := range 4 {
+		if err := bq.Enqueue(bytes.Repeat([]byte{'a'}, msgLen+i)); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
 
-	bq, err := NewMmapQueue("1/2/3/4/5/6")
-	if !os.IsNotExist(errors.Unwrap(errors.Unwrap(err))) || bq != nil {
-		t.Fatalf("expected file not exists error, returned: %v", err)
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
 	}
-}
 
-func TestNewBigqueueTooLargeArena(t *testing.T) {
-	t.Parallel()
+	backlog := 4*(msgLen+cInt64Size) + 6
+	for i := range 4 {
+		if n, err := c.NextLen(); err != nil {
+			t.Fatalf("NextLen failed :: %v", err)
+		} else if n != msgLen+i {
+			t.Fatalf("unexpected length, exp: %d, actual: %d", msgLen+i, n)
+		}
+		if n := c.BacklogBytes(); n != backlog {
+			t.Fatalf("unexpected backlog, exp: %d, actual: %d", backlog, n)
+		}
 
-	testDir := t.TempDir()
-	arenaSize := math.MaxInt64
-	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
-	if bq != nil || !strings.Contains(err.Error(), "file too large") &&
-		!strings.Contains(err.Error(), "no space left on device") {
+		if _, err := c.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue :: %v", err)
+		}
+		backlog -= msgLen + i + cInt64Size
+	}
 
-		t.Fatalf("expected file too large, returned: %v", err)
+	if n := c.BacklogBytes(); n != 0 {
+		t.Fatalf("BacklogBytes should be 0 for empty queue, returned: %d", n)
+	}
+	if n := bq.BacklogBytes(); n != 4*(msgLen+cInt64Size)+6 {
+		t.Fatalf("BacklogBytes of default consumer should be unaffected, returned: %d", n)
 	}
 }
 
-func TestLimitedMemoryErr(t *testing.T) {
+func TestEnqueueBatch(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
-	arenaSize := os.Getpagesize() * 2
-	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize), SetMaxInMemArenas(1))
-	if err != ErrTooFewInMemArenas || bq != nil {
-		t.Fatalf("expected too few in mem arenas error, returned: %v", err)
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
-}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
 
-func TestLimitedMemoryNoErr(t *testing.T) {
-	t.Parallel()
+	if err := bq.EnqueueBatch(nil); err != nil {
+		t.Fatalf("EnqueueBatch failed for empty batch :: %v", err)
+	}
 
-	testDir := t.TempDir()
-	arenaSize := os.Getpagesize() * 2
-	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize), SetMaxInMemArenas(0))
-	if err != nil || bq == nil {
-		t.Fatalf("expected no error, returned: %v", err)
+	msgs := make([][]byte, 0, 10)
+	for i := range 10 {
+		msgs = append(msgs, bytes.Repeat([]byte(strconv.Itoa(i)), 1000))
 	}
-	if err := bq.Close(); err != nil {
-		t.Fatalf("error in closing bigqueue :: %v", err)
+	if err := bq.EnqueueBatch(msgs); err != nil {
+		t.Fatalf("EnqueueBatch failed :: %v", err)
+	}
+
+	// allocation of the 3rd arena fails, none of the messages should be visible
+	if err := os.Mkdir(filepath.Join(testDir, "2"+cArenaFileSuffix), cFilePerm); err != nil {
+		t.Fatalf("unable to create directory :: %v", err)
+	}
+	if err := bq.EnqueueBatch(msgs); err == nil {
+		t.Fatalf("EnqueueBatch should fail when arena cannot be allocated")
+	}
+
+	for i := range msgs {
+		if msg, err := bq.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue :: %v", err)
+		} else if !bytes.Equal(msg, msgs[i]) {
+			t.Fatalf("messages don't match for element %d", i)
+		}
+	}
+	if !bq.IsEmpty() {
+		t.Fatalf("messages of a failed batch should not be visible")
 	}
 }
 
-func runTestLimitedMemory(t *testing.T, messageSize, arenaSize, maxInMemArenas int) {
-	t.Helper()
+func TestEnqueueV(t *testing.T) {
+	t.Parallel()
 
 	testDir := t.TempDir()
-	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize),
-		SetMaxInMemArenas(maxInMemArenas))
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to get BigQueue :: %v", err)
 	}
 	defer func() {
 		if err := bq.Close(); err != nil {
@@ -594,109 +814,1153 @@ func runTestLimitedMemory(t *testing.T, messageSize, arenaSize, maxInMemArenas i
 		}
 	}()
 
-	msg := bytes.Repeat([]byte("a"), messageSize)
-	for range 11 {
-		if err := bq.Enqueue(msg); err != nil {
-			t.Fatalf("enqueue failed :: %v", err)
-		}
-
-		checkInMemArenaInvariant(t, bq)
+	header := []byte("header:")
+	if err := bq.EnqueueV(); err != nil {
+		t.Fatalf("EnqueueV failed :: %v", err)
 	}
 
-	for range 5 {
-		if _, err := bq.Dequeue(); err != nil {
-			t.Fatalf("dequeue failed :: %v", err)
-		}
-
-		checkInMemArenaInvariant(t, bq)
+	// the first part fills the first arena exactly
+	body := bytes.Repeat([]byte("b"), arenaSize-cInt64Size*2-len(header))
+	if err := bq.EnqueueV(header, body, nil, header); err != nil {
+		t.Fatalf("EnqueueV failed :: %v", err)
 	}
 
-	for range 5 {
-		if err := bq.Enqueue(msg); err != nil {
-			t.Fatalf("enqueue failed :: %v", err)
+	// parts span across multiple arenas
+	for i := range 5 {
+		body := bytes.Repeat([]byte(strconv.Itoa(i)), arenaSize/2)
+		if err := bq.EnqueueV(header, body, body); err != nil {
+			t.Fatalf("EnqueueV failed :: %v", err)
 		}
-
-		checkInMemArenaInvariant(t, bq)
 	}
 
-	if bq.IsEmpty() {
-		t.Fatalf("BigQueue should not be empty")
+	if msg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if len(msg) != 0 {
+		t.Fatalf("expected empty message, actual length: %d", len(msg))
 	}
 
-	if err := bq.Close(); err != nil {
-		t.Fatalf("error in closing bigqueue :: %v", err)
-	}
-	if bqTemp, err := NewMmapQueue(testDir, SetArenaSize(arenaSize),
-		SetMaxInMemArenas(maxInMemArenas)); err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
-	} else {
-		bq = bqTemp
+	exp := slices.Concat(header, body, header)
+	if msg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if !bytes.Equal(msg, exp) {
+		t.Fatalf("unequal messages")
 	}
 
-	for range 7 {
-		if poppedMsg, err := bq.Dequeue(); err != nil {
+	for i := range 5 {
+		body := bytes.Repeat([]byte(strconv.Itoa(i)), arenaSize/2)
+		exp := slices.Concat(header, body, body)
+		if msg, err := bq.Dequeue(); err != nil {
 			t.Fatalf("unable to dequeue :: %v", err)
-		} else if !bytes.Equal(msg, poppedMsg) {
-			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+		} else if !bytes.Equal(msg, exp) {
+			t.Fatalf("messages don't match for element %d", i)
 		}
-		checkInMemArenaInvariant(t, bq)
+	}
+}
 
-		if err := bq.Enqueue(msg); err != nil {
+func TestSequence(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+
+	if err := bq.EnqueueBatch([][]byte{[]byte("0"), []byte("1"), []byte("2")}); err != nil {
+		t.Fatalf("EnqueueBatch failed :: %v", err)
+	}
+	for i := 3; i < 10; i++ {
+		if err := bq.EnqueueString(strconv.Itoa(i)); err != nil {
 			t.Fatalf("enqueue failed :: %v", err)
 		}
-		checkInMemArenaInvariant(t, bq)
 	}
 
-	for range 11 {
-		if _, err := bq.Dequeue(); err != nil {
-			t.Fatalf("dequeue failed :: %v", err)
+	if _, err := bq.DequeueBatch(2, 0); err != nil {
+		t.Fatalf("DequeueBatch failed :: %v", err)
+	}
+	if _, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	}
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	// sequence numbers are persisted
+	bq, err = NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
 		}
+	}()
 
-		checkInMemArenaInvariant(t, bq)
+	if _, err := bq.DequeueMsg(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	}
+	c, err := bq.FromConsumer("consumer", &Consumer{mq: bq, name: cDefaultConsumer, base: bq.dc})
+	if err != nil {
+		t.Fatalf("error in copying consumer :: %v", err)
+	}
+	for i := 4; i < 10; i++ {
+		if msg, err := c.DequeueMsg(); err != nil {
+			t.Fatalf("unable to dequeue :: %v", err)
+		} else if msg.Seq != uint64(i) || string(msg.Data) != strconv.Itoa(i) {
+			t.Fatalf("unexpected message, exp: %d, seq: %d, data: %s", i, msg.Seq, string(msg.Data))
+		}
 	}
-}
 
-func TestLimitedMemorySmallMessage(t *testing.T) {
-	t.Parallel()
-	arenaSize := os.Getpagesize() * 2
-	runTestLimitedMemory(t, arenaSize-16, arenaSize, 3)
+	if _, err := c.DequeueMsg(); err != ErrEmptyQueue {
+		t.Fatalf("DequeueMsg should return empty queue error, returned: %v", err)
+	}
 }
 
-func TestLimitedMemoryLargeMessage(t *testing.T) {
+func TestEnqueueSmallMessage(t *testing.T) {
 	t.Parallel()
-	arenaSize := os.Getpagesize() * 2
-	runTestLimitedMemory(t, arenaSize*4, arenaSize, 3)
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg := []byte("abcdefghij")
+	if err := bq.Enqueue(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if bq.IsEmpty() {
+		t.Fatalf("BigQueue should not be empty")
+	}
+
+	if poppedMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if !bytes.Equal(msg, poppedMsg) {
+		t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	}
 }
 
-func TestLimitedMemoryHugeMessage1(t *testing.T) {
+func TestEnqueueLargeMessage(t *testing.T) {
 	t.Parallel()
-	arenaSize := os.Getpagesize() * 2
-	runTestLimitedMemory(t, arenaSize*7-8, arenaSize, 5)
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg := make([]byte, 0, cDefaultArenaSize-8)
+	for range cDefaultArenaSize - 8 {
+		m := []byte("a")
+		msg = append(msg, m...)
+	}
+	if err := bq.Enqueue(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if deQueuedMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("Dequeue failed :: %v", err)
+	} else if !bytes.Equal(deQueuedMsg, msg) {
+		t.Fatalf("dequeued and enqueued messages are not equal")
+	}
+
+	if !bq.IsEmpty() {
+		t.Fatalf("IsEmpty should return true")
+	}
 }
 
-func TestLimitedMemoryHugeMessage2(t *testing.T) {
+func TestEnqueueOverlapLength(t *testing.T) {
 	t.Parallel()
-	arenaSize := os.Getpagesize() * 2
-	runTestLimitedMemory(t, arenaSize*7, arenaSize, 5)
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg1 := make([]byte, 0, cDefaultArenaSize-12)
+	for range cDefaultArenaSize - 12 {
+		m := []byte("a")
+		msg1 = append(msg1, m...)
+	}
+	if err := bq.Enqueue(msg1); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	msg2 := make([]byte, 0, cDefaultArenaSize-4)
+	for range cDefaultArenaSize - 4 {
+		m := []byte("a")
+		msg2 = append(msg2, m...)
+	}
+	if err := bq.Enqueue(msg2); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if dequeueMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("Dequeue failed :: %v", err)
+	} else if !bytes.Equal(dequeueMsg, msg1) {
+		t.Fatalf("dequeued and enqeued messages are not equal")
+	}
+
+	if dequeueMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("Dequeue failed :: %v", err)
+	} else if !bytes.Equal(dequeueMsg, msg2) {
+		t.Fatalf("dequeued and enqeued messages are not equal")
+	}
+
+	if !bq.IsEmpty() {
+		t.Fatalf("queue should be empty")
+	}
 }
 
-func TestLimitedMemoryExactMessage1(t *testing.T) {
+func TestEnqueueLargeNumberOfMessages(t *testing.T) {
 	t.Parallel()
-	arenaSize := os.Getpagesize() * 2
-	runTestLimitedMemory(t, arenaSize*3-8, arenaSize, 5)
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %s", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	numMessages := 10
+	lengths := make([]int, 0, numMessages)
+	alphabets := "abcdefghijklmnopqrstuvwxyz"
+	for range numMessages {
+		msgLen := rand.Intn(cDefaultArenaSize) + cDefaultArenaSize
+		lengths = append(lengths, msgLen)
+		msg := make([]byte, 0)
+		for {
+			if msgLen > len(alphabets) {
+				msg = append(msg, []byte(alphabets)...)
+				msgLen -= len(alphabets)
+			} else {
+				msg = append(msg, []byte(alphabets[0:msgLen])...)
+				break
+			}
+		}
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("unable to enqueue message :: %v", err)
+		}
+	}
+
+	for i := range numMessages {
+		if msg, err := bq.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue message :: %v", err)
+		} else if len(msg) != lengths[i] {
+			t.Fatalf("enqueued and dequeued lengths don't match for msg no %d", i)
+		}
+	}
+
+	if !bq.IsEmpty() {
+		t.Fatalf("queue should be empty")
+	}
 }
 
-func TestLimitedMemoryExactMessage2(t *testing.T) {
+func TestEnqueueZeroLengthMessage(t *testing.T) {
 	t.Parallel()
-	arenaSize := os.Getpagesize() * 2
-	runTestLimitedMemory(t, arenaSize-8, arenaSize, 3)
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	emptyMsg := make([]byte, 0)
+	if err := bq.Enqueue(emptyMsg); err != nil {
+		t.Fatalf("unable to enqueue empty message :: %v", err)
+	}
+
+	if bq.IsEmpty() {
+		t.Fatalf("IsEmpty should return false if empty message is present in queue")
+	}
+
+	if deQueuedMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue empty message")
+	} else if !bytes.Equal(deQueuedMsg, emptyMsg) {
+		t.Fatalf("dequeued and enqueued messages are not equal")
+	}
+
+	if !bq.IsEmpty() {
+		t.Fatalf("queue should be empty now")
+	}
 }
 
-func TestReadWriteString(t *testing.T) {
+func TestEnqueueWhenMessageLengthFits(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
-	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	arenaSize := 4 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg1 := bytes.Repeat([]byte("a"), arenaSize-16)
+	if err := bq.Enqueue(msg1); err != nil {
+		t.Fatalf("unable to enqueue msg1: %s", err)
+	}
+
+	msg2 := bytes.Repeat([]byte("b"), 3*arenaSize)
+	if err := bq.Enqueue(msg2); err != nil {
+		t.Fatalf("unable to enqueue msg2: %s", err)
+	}
+
+	if _, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue msg1: %s", err)
+	}
+	if _, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue msg2: %s", err)
+	}
+}
+
+func TestReadWriteCornerCases(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+
+	for i := 1; i < 10; i++ {
+		msgLength := i*arenaSize/2 - 8
+		if i == 5 {
+			msgLength -= 8
+		}
+		msg := bytes.Repeat([]byte("a"), msgLength)
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+
+		if bq.IsEmpty() {
+			t.Fatalf("BigQueue should not be empty")
+		}
+
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+		if bqTemp, err := NewMmapQueue(testDir, SetArenaSize(arenaSize)); err != nil {
+			t.Fatalf("unable to get BigQueue: %v", err)
+		} else {
+			bq = bqTemp
+		}
+
+		if poppedMsg, err := bq.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue :: %v", err)
+		} else if !bytes.Equal(msg, poppedMsg) {
+			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+		}
+
+		if !bq.IsEmpty() {
+			t.Fatalf("BigQueue should be empty")
+		}
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+}
+
+func TestArenaSize(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg := []byte("abcdefghij")
+	if err := bq.Enqueue(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if bq.IsEmpty() {
+		t.Fatalf("BigQueue should not be empty")
+	}
+
+	if poppedMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if !bytes.Equal(msg, poppedMsg) {
+		t.Fatalf("unequal length, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	}
+}
+
+func TestArenaSize2(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := os.Getpagesize() * 2
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg := []byte("abcdefghij")
+	for range arenaSize / len(msg) * 4 {
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
+
+	if bq.IsEmpty() {
+		t.Fatalf("BigQueue should not be empty")
+	}
+
+	for range arenaSize / len(msg) * 4 {
+		if poppedMsg, err := bq.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue :: %v", err)
+		} else if !bytes.Equal(msg, poppedMsg) {
+			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+		}
+	}
+}
+
+func TestArenaSize3(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := os.Getpagesize()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg := []byte("abcdefgh")
+	for range arenaSize / len(msg) * 4 {
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
+
+	if bq.IsEmpty() {
+		t.Fatalf("BigQueue should not be empty")
+	}
+
+	for range arenaSize / len(msg) * 4 {
+		if poppedMsg, err := bq.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue :: %v", err)
+		} else if !bytes.Equal(msg, poppedMsg) {
+			t.Fatalf("unequal length, eq: %s, dq: %s", string(msg), string(poppedMsg))
+		}
+	}
+}
+
+func TestArenaSizeFail(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	_, err := NewMmapQueue(testDir, SetArenaSize(os.Getpagesize()/2))
+	if err != ErrTooSmallArenaSize {
+		t.Fatalf("expected error: %v, got: %v", ErrTooSmallArenaSize, err)
+	}
+}
+
+func TestArenaSizeFail2(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+
+	msg := []byte("abcdefghij")
+	if err := bq.Enqueue(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+	if _, err := NewMmapQueue(testDir, SetArenaSize(6*1024)); err != ErrInvalidArenaSize {
+		t.Fatalf("expected invalid arena size error, got: %v", err)
+	}
+}
+
+func TestArenaSizeNotMultiple(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(5732))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+
+	msg := []byte("abcdefghij")
+	if err := bq.Enqueue(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+	if tempBq, err := NewMmapQueue(testDir, SetArenaSize(5732)); err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	} else {
+		bq = tempBq
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if poppedMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if !bytes.Equal(msg, poppedMsg) {
+		t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	}
+}
+
+func TestNewBigqueueNoFolder(t *testing.T) {
+	t.Parallel()
+
+	bq, err := NewMmapQueue("1/2/3/4/5/6")
+	if !os.IsNotExist(errors.Unwrap(errors.Unwrap(err))) || bq != nil {
+		t.Fatalf("expected file not exists error, returned: %v", err)
+	}
+}
+
+func TestNewBigqueueTooLargeArena(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := math.MaxInt64
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if bq != nil || !strings.Contains(err.Error(), "file too large") &&
+		!strings.Contains(err.Error(), "no space left on device") {
+
+		t.Fatalf("expected file too large, returned: %v", err)
+	}
+}
+
+func TestLimitedMemoryErr(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := os.Getpagesize() * 2
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize), SetMaxInMemArenas(1))
+	if err != ErrTooFewInMemArenas || bq != nil {
+		t.Fatalf("expected too few in mem arenas error, returned: %v", err)
+	}
+}
+
+func TestLimitedMemoryNoErr(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := os.Getpagesize() * 2
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize), SetMaxInMemArenas(0))
+	if err != nil || bq == nil {
+		t.Fatalf("expected no error, returned: %v", err)
+	}
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+}
+
+func runTestLimitedMemory(t *testing.T, messageSize, arenaSize, maxInMemArenas int) {
+	t.Helper()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize),
+		SetMaxInMemArenas(maxInMemArenas))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg := bytes.Repeat([]byte("a"), messageSize)
+	for range 11 {
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+
+		checkInMemArenaInvariant(t, bq)
+	}
+
+	for range 5 {
+		if _, err := bq.Dequeue(); err != nil {
+			t.Fatalf("dequeue failed :: %v", err)
+		}
+
+		checkInMemArenaInvariant(t, bq)
+	}
+
+	for range 5 {
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+
+		checkInMemArenaInvariant(t, bq)
+	}
+
+	if bq.IsEmpty() {
+		t.Fatalf("BigQueue should not be empty")
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+	if bqTemp, err := NewMmapQueue(testDir, SetArenaSize(arenaSize),
+		SetMaxInMemArenas(maxInMemArenas)); err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	} else {
+		bq = bqTemp
+	}
+
+	for range 7 {
+		if poppedMsg, err := bq.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue :: %v", err)
+		} else if !bytes.Equal(msg, poppedMsg) {
+			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+		}
+		checkInMemArenaInvariant(t, bq)
+
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+		checkInMemArenaInvariant(t, bq)
+	}
+
+	for range 11 {
+		if _, err := bq.Dequeue(); err != nil {
+			t.Fatalf("dequeue failed :: %v", err)
+		}
+
+		checkInMemArenaInvariant(t, bq)
+	}
+}
+
+func TestLimitedMemorySmallMessage(t *testing.T) {
+	t.Parallel()
+	arenaSize := os.Getpagesize() * 2
+	runTestLimitedMemory(t, arenaSize-16, arenaSize, 3)
+}
+
+func TestLimitedMemoryLargeMessage(t *testing.T) {
+	t.Parallel()
+	arenaSize := os.Getpagesize() * 2
+	runTestLimitedMemory(t, arenaSize*4, arenaSize, 3)
+}
+
+func TestLimitedMemoryHugeMessage1(t *testing.T) {
+	t.Parallel()
+	arenaSize := os.Getpagesize() * 2
+	runTestLimitedMemory(t, arenaSize*7-8, arenaSize, 5)
+}
+
+func TestLimitedMemoryHugeMessage2(t *testing.T) {
+	t.Parallel()
+	arenaSize := os.Getpagesize() * 2
+	runTestLimitedMemory(t, arenaSize*7, arenaSize, 5)
+}
+
+func TestLimitedMemoryExactMessage1(t *testing.T) {
+	t.Parallel()
+	arenaSize := os.Getpagesize() * 2
+	runTestLimitedMemory(t, arenaSize*3-8, arenaSize, 5)
+}
+
+func TestLimitedMemoryExactMessage2(t *testing.T) {
+	t.Parallel()
+	arenaSize := os.Getpagesize() * 2
+	runTestLimitedMemory(t, arenaSize-8, arenaSize, 3)
+}
+
+func TestReadWriteString(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	// message larger than arena size, coinciding arena end
+	msg := strings.Repeat("a", 2*8*1024-8)
+	if err := bq.EnqueueString(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if bq.IsEmpty() {
+		t.Fatalf("BigQueue should not be empty")
+	}
+
+	if poppedMsg, err := bq.DequeueString(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if msg != poppedMsg {
+		t.Fatalf("unequal messages, eq: %s, dq: %s", msg, poppedMsg)
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	if bqTemp, err := NewMmapQueue(testDir, SetArenaSize(8*1024)); err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	} else {
+		bq = bqTemp
+	}
+
+	// enqueue empty string
+	if err := bq.EnqueueString(""); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if poppedMsg, err := bq.DequeueString(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if poppedMsg != "" {
+		t.Fatalf("unequal messages, eq: <>, dq: %s", poppedMsg)
+	}
+
+	// enqueue small string
+	smallStr := "bigqueue is awesome"
+	if err := bq.EnqueueString(smallStr); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if poppedMsg, err := bq.DequeueString(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if smallStr != poppedMsg {
+		t.Fatalf("unequal messages, eq: <>, dq: %s", poppedMsg)
+	}
+}
+
+func TestConsumerSmallMessage(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg := []byte("test message")
+	if err := bq.Enqueue(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if bq.IsEmpty() {
+		t.Fatalf("BigQueue should not be empty")
+	}
+
+	// enqueue message using default consumer
+	if poppedMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if !bytes.Equal(msg, poppedMsg) {
+		t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+
+	if c.IsEmpty() {
+		t.Fatalf("BigQueue should not be empty for consumer")
+	}
+
+	if poppedMsg, err := c.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue from consumer :: %v", err)
+	} else if !bytes.Equal(msg, poppedMsg) {
+		t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	}
+}
+
+func TestConsumerReadWriteCornerCases(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+
+	for i := 1; i < 10; i++ {
+		msgLength := i*arenaSize/2 - 8
+		if i == 5 {
+			msgLength -= 8
+		}
+		msg := bytes.Repeat([]byte("a"), msgLength)
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+
+		if bq.IsEmpty() {
+			t.Fatalf("BigQueue should not be empty")
+		}
+
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+		if bqTemp, err := NewMmapQueue(testDir, SetArenaSize(arenaSize)); err != nil {
+			t.Fatalf("unable to get BigQueue: %v", err)
+		} else {
+			bq = bqTemp
+		}
+
+		if poppedMsg, err := bq.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue :: %v", err)
+		} else if !bytes.Equal(msg, poppedMsg) {
+			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+		}
+
+		if !bq.IsEmpty() {
+			t.Fatalf("BigQueue should be empty")
+		}
+
+		cur, err := bq.NewConsumer("consumer" + strconv.FormatInt(int64(i), 10))
+		if err != nil {
+			t.Fatalf("error in creating a consumer :: %v", err)
+		}
+
+		if cur.IsEmpty() {
+			t.Fatalf("BigQueue should not be empty for consumer")
+		}
+
+		for j := 1; j <= i-1; j++ {
+			if _, err := cur.Dequeue(); err != nil {
+				t.Fatalf("unable to dequeue from consumer :: %v", err)
+			}
+		}
+
+		for j := 1; j <= i; j++ {
+			c, err := bq.NewConsumer("consumer" + strconv.FormatInt(int64(j), 10))
+			if err != nil {
+				t.Fatalf("error in creating a consumer :: %v", err)
+			}
+
+			if poppedMsg, err := c.DequeueString(); err != nil {
+				t.Fatalf("unable to dequeue from consumer :: %v", err)
+			} else if string(msg) != poppedMsg {
+				t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), poppedMsg)
+			}
+
+			if !c.IsEmpty() {
+				t.Fatalf("BigQueue should be empty")
+			}
+		}
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+}
+
+func TestCopyConsumerReadWriteCornerCases(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+
+	for i := 1; i < 10; i++ {
+		msgLength := i*arenaSize/2 - 8
+		if i == 5 {
+			msgLength -= 8
+		}
+		msg := bytes.Repeat([]byte("a"), msgLength)
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+
+		if bq.IsEmpty() {
+			t.Fatalf("BigQueue should not be empty")
+		}
+
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+		if bqTemp, err := NewMmapQueue(testDir, SetArenaSize(arenaSize)); err != nil {
+			t.Fatalf("unable to get BigQueue: %v", err)
+		} else {
+			bq = bqTemp
+		}
+
+		if poppedMsg, err := bq.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue :: %v", err)
+		} else if !bytes.Equal(msg, poppedMsg) {
+			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+		}
+
+		if !bq.IsEmpty() {
+			t.Fatalf("BigQueue should be empty")
+		}
+
+		lastC, err := bq.NewConsumer("consumer" + strconv.FormatInt(int64(i-1), 10))
+		if err != nil {
+			t.Fatalf("error in creating a consumer :: %v", err)
+		}
+		cur, err := bq.FromConsumer("consumer"+strconv.FormatInt(int64(i), 10), lastC)
+		if err != nil {
+			t.Fatalf("error in creating a consumer :: %v", err)
+		}
+
+		if cur.IsEmpty() {
+			t.Fatalf("BigQueue should not be empty for consumer")
+		}
+
+		for j := 1; j <= i; j++ {
+			c, err := bq.NewConsumer("consumer" + strconv.FormatInt(int64(j), 10))
+			if err != nil {
+				t.Fatalf("error in creating a consumer :: %v", err)
+			}
+
+			if poppedMsg, err := c.DequeueString(); err != nil {
+				t.Fatalf("unable to dequeue from consumer :: %v", err)
+			} else if string(msg) != poppedMsg {
+				t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), poppedMsg)
+			}
+
+			if !c.IsEmpty() {
+				t.Fatalf("BigQueue should be empty")
+			}
+		}
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+}
+
+func TestConsumersFromDifferentQueuesErr(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+
+	c1, err := bq.NewConsumer("consumer1")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	bq, err = NewMmapQueue(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if _, err := bq.FromConsumer("consumer1", c1); err != ErrDifferentQueues {
+		t.Fatalf("expected consumers from different queues error, returned: %v", err)
+	}
+}
+
+func TestConsumersFromReopenedQueue(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+
+	for _, msg := range []string{"elem1", "elem2"} {
+		if err := bq.EnqueueString(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
+
+	c1, err := bq.NewConsumer("consumer1")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	if _, err := c1.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue from consumer :: %v", err)
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	bq, err = NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	c2, err := bq.FromConsumer("consumer2", c1)
+	if err != nil {
+		t.Fatalf("error in copying consumer of reopened queue :: %v", err)
+	}
+	if poppedMsg, err := c2.DequeueString(); err != nil {
+		t.Fatalf("unable to dequeue from consumer :: %v", err)
+	} else if poppedMsg != "elem2" {
+		t.Fatalf("unequal messages, eq: elem2, dq: %s", poppedMsg)
+	}
+}
+
+func TestConsumersFromRestoredQueue(t *testing.T) {
+	t.Parallel()
+
+	srcDir := t.TempDir()
+	bq1, err := NewMmapQueue(srcDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := bq1.EnqueueString(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
+	if err := bq1.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	if err := Restore(srcDir, dstDir); err != nil {
+		t.Fatalf("unable to restore queue :: %v", err)
+	}
+
+	// both the handles are open and their consumers diverge
+	bq1, err = NewMmapQueue(srcDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq1.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+	bq2, err := NewMmapQueue(dstDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+
+	c1, err := bq1.NewConsumer("x")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	for range 2 {
+		if _, err := c1.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue from consumer :: %v", err)
+		}
+	}
+	if _, err := bq2.NewConsumer("x"); err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+
+	c2, err := bq2.FromConsumer("y", c1)
+	if err != nil {
+		t.Fatalf("error in copying consumer of another handle :: %v", err)
+	}
+	if poppedMsg, err := c2.DequeueString(); err != nil || poppedMsg != "c" {
+		t.Fatalf("unequal messages, eq: c, dq: %s :: %v", poppedMsg, err)
+	}
+
+	// consumers of a closed handle of another directory cannot be copied
+	c3, err := bq2.NewConsumer("z")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	if err := bq2.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+	if _, err := bq1.FromConsumer("z", c3); err != ErrDifferentQueues {
+		t.Fatalf("expected consumers from different queues error, returned: %v", err)
+	}
+}
+
+func TestQueueID(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+
+	id := bq.ID()
+	if len(id) != 36 || id[14] != '4' {
+		t.Fatalf("queue ID should be a version 4 UUID, actual: %v", id)
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	bq, err = NewMmapQueue(testDir)
 	if err != nil {
 		t.Fatalf("unable to get BigQueue: %v", err)
 	}
@@ -706,57 +1970,67 @@ func TestReadWriteString(t *testing.T) {
 		}
 	}()
 
-	// message larger than arena size, coinciding arena end
-	msg := strings.Repeat("a", 2*8*1024-8)
-	if err := bq.EnqueueString(msg); err != nil {
-		t.Fatalf("enqueue failed :: %v", err)
+	if bq.ID() != id {
+		t.Fatalf("queue ID should be persisted, exp: %v, actual: %v", id, bq.ID())
 	}
 
-	if bq.IsEmpty() {
-		t.Fatalf("BigQueue should not be empty")
+	other, err := NewMmapQueue(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
 	}
+	defer func() {
+		if err := other.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
 
-	if poppedMsg, err := bq.DequeueString(); err != nil {
-		t.Fatalf("unable to dequeue :: %v", err)
-	} else if msg != poppedMsg {
-		t.Fatalf("unequal messages, eq: %s, dq: %s", msg, poppedMsg)
+	if other.ID() == id {
+		t.Fatalf("queue IDs of different queues should not match")
 	}
+}
 
-	if err := bq.Close(); err != nil {
-		t.Fatalf("error in closing bigqueue :: %v", err)
-	}
+// writeOldMetadata rewrites the metadata file in dir in the format of
+// given version, which is either 1, 2 or 3, from the current format.
+func writeOldMetadata(t *testing.T, dir string, version int) {
+	t.Helper()
 
-	if bqTemp, err := NewMmapQueue(testDir, SetArenaSize(8*1024)); err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
-	} else {
-		bq = bqTemp
+	metaPath := filepath.Join(dir, cMetadataFileName)
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		t.Fatalf("unable to read metadata :: %v", err)
 	}
 
-	// enqueue empty string
-	if err := bq.EnqueueString(""); err != nil {
-		t.Fatalf("enqueue failed :: %v", err)
+	// versions 3 and 4 have the same layout as the current version
+	if version >= 3 {
+		data[0] = byte(version)
+		if err := os.WriteFile(metaPath, data, cFilePerm); err != nil {
+			t.Fatalf("unable to write metadata :: %v", err)
+		}
+		return
 	}
 
-	if poppedMsg, err := bq.DequeueString(); err != nil {
-		t.Fatalf("unable to dequeue :: %v", err)
-	} else if poppedMsg != "" {
-		t.Fatalf("unequal messages, eq: <>, dq: %s", poppedMsg)
+	// version 2 stores neither the next sequence nor the sequence of consumers
+	old := append([]byte{}, data[:72]...)
+	for base := cMetadataSize; base < len(data); {
+		length := int(binary.LittleEndian.Uint64(data[base:]))
+		old = append(old, data[base:base+24]...)
+		old = append(old, data[base+32:base+32+length]...)
+		base += 32 + length
 	}
+	old[0] = 2
 
-	// enqueue small string
-	smallStr := "bigqueue is awesome"
-	if err := bq.EnqueueString(smallStr); err != nil {
-		t.Fatalf("enqueue failed :: %v", err)
+	// version 1 doesn't store the queue ID
+	if version == 1 {
+		old = append(old[:56:56], old[72:]...)
+		old[0] = 1
 	}
 
-	if poppedMsg, err := bq.DequeueString(); err != nil {
-		t.Fatalf("unable to dequeue :: %v", err)
-	} else if smallStr != poppedMsg {
-		t.Fatalf("unequal messages, eq: <>, dq: %s", poppedMsg)
+	if err := os.WriteFile(metaPath, old, cFilePerm); err != nil {
+		t.Fatalf("unable to write metadata :: %v", err)
 	}
 }
 
-func TestConsumerSmallMessage(t *testing.T) {
+func TestMetadataMigrateV1(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
@@ -764,45 +2038,48 @@ func TestConsumerSmallMessage(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unable to get BigQueue: %v", err)
 	}
+	if err := bq.EnqueueString("elem"); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+	if _, err := bq.NewConsumer("consumer"); err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	// rewrite the metadata file in version 1 format, without queue ID
+	writeOldMetadata(t, testDir, 1)
+
+	bq, err = NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
 	defer func() {
 		if err := bq.Close(); err != nil {
 			t.Fatalf("error in closing bigqueue :: %v", err)
 		}
 	}()
 
-	msg := []byte("test message")
-	if err := bq.Enqueue(msg); err != nil {
-		t.Fatalf("enqueue failed :: %v", err)
-	}
-
-	if bq.IsEmpty() {
-		t.Fatalf("BigQueue should not be empty")
-	}
-
-	// enqueue message using default consumer
-	if poppedMsg, err := bq.Dequeue(); err != nil {
-		t.Fatalf("unable to dequeue :: %v", err)
-	} else if !bytes.Equal(msg, poppedMsg) {
-		t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	if bq.md.getVersion() != cMetadataVersion || bq.id == [16]byte{} {
+		t.Fatalf("metadata should be migrated, version: %v", bq.md.getVersion())
 	}
 
 	c, err := bq.NewConsumer("consumer")
 	if err != nil {
 		t.Fatalf("error in creating a consumer :: %v", err)
 	}
-
-	if c.IsEmpty() {
-		t.Fatalf("BigQueue should not be empty for consumer")
+	if bq.md.getNumConsumers() != 2 {
+		t.Fatalf("consumers should be preserved, actual: %v", bq.md.getNumConsumers())
 	}
-
-	if poppedMsg, err := c.Dequeue(); err != nil {
+	if poppedMsg, err := c.DequeueString(); err != nil {
 		t.Fatalf("unable to dequeue from consumer :: %v", err)
-	} else if !bytes.Equal(msg, poppedMsg) {
-		t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+	} else if poppedMsg != "elem" {
+		t.Fatalf("unequal messages, eq: elem, dq: %s", poppedMsg)
 	}
 }
 
-func TestConsumerReadWriteCornerCases(t *testing.T) {
+func TestMetadataMigrateV2(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
@@ -812,78 +2089,65 @@ func TestConsumerReadWriteCornerCases(t *testing.T) {
 		t.Fatalf("unable to get BigQueue: %v", err)
 	}
 
-	for i := 1; i < 10; i++ {
-		msgLength := i*arenaSize/2 - 8
-		if i == 5 {
-			msgLength -= 8
-		}
-		msg := bytes.Repeat([]byte("a"), msgLength)
-		if err := bq.Enqueue(msg); err != nil {
+	// elements span across arenas
+	for i := range 10 {
+		if err := bq.Enqueue(bytes.Repeat([]byte(strconv.Itoa(i)), arenaSize/3)); err != nil {
 			t.Fatalf("enqueue failed :: %v", err)
 		}
+	}
+	c1, err := bq.NewConsumer("consumer1")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	if _, err := bq.NewConsumer("consumer2"); err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	if _, err := c1.DequeueBatch(4, 0); err != nil {
+		t.Fatalf("DequeueBatch failed :: %v", err)
+	}
+	if _, err := bq.DequeueBatch(10, 0); err != nil {
+		t.Fatalf("DequeueBatch failed :: %v", err)
+	}
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
 
-		if bq.IsEmpty() {
-			t.Fatalf("BigQueue should not be empty")
-		}
-
+	writeOldMetadata(t, testDir, 2)
+	bq, err = NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
 		if err := bq.Close(); err != nil {
 			t.Fatalf("error in closing bigqueue :: %v", err)
 		}
-		if bqTemp, err := NewMmapQueue(testDir, SetArenaSize(arenaSize)); err != nil {
-			t.Fatalf("unable to get BigQueue: %v", err)
-		} else {
-			bq = bqTemp
-		}
-
-		if poppedMsg, err := bq.Dequeue(); err != nil {
-			t.Fatalf("unable to dequeue :: %v", err)
-		} else if !bytes.Equal(msg, poppedMsg) {
-			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
-		}
+	}()
 
-		if !bq.IsEmpty() {
-			t.Fatalf("BigQueue should be empty")
-		}
+	if bq.md.getVersion() != cMetadataVersion {
+		t.Fatalf("metadata should be migrated, version: %v", bq.md.getVersion())
+	}
 
-		cur, err := bq.NewConsumer("consumer" + strconv.FormatInt(int64(i), 10))
+	// sequence numbers are computed by counting the elements
+	for name, seq := range map[string]uint64{"consumer1": 4, "consumer2": 0} {
+		c, err := bq.NewConsumer(name)
 		if err != nil {
 			t.Fatalf("error in creating a consumer :: %v", err)
 		}
-
-		if cur.IsEmpty() {
-			t.Fatalf("BigQueue should not be empty for consumer")
-		}
-
-		for j := 1; j <= i-1; j++ {
-			if _, err := cur.Dequeue(); err != nil {
-				t.Fatalf("unable to dequeue from consumer :: %v", err)
-			}
-		}
-
-		for j := 1; j <= i; j++ {
-			c, err := bq.NewConsumer("consumer" + strconv.FormatInt(int64(j), 10))
-			if err != nil {
-				t.Fatalf("error in creating a consumer :: %v", err)
-			}
-
-			if poppedMsg, err := c.DequeueString(); err != nil {
-				t.Fatalf("unable to dequeue from consumer :: %v", err)
-			} else if string(msg) != poppedMsg {
-				t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), poppedMsg)
-			}
-
-			if !c.IsEmpty() {
-				t.Fatalf("BigQueue should be empty")
-			}
+		if msg, err := c.DequeueMsg(); err != nil {
+			t.Fatalf("unable to dequeue from consumer :: %v", err)
+		} else if msg.Seq != seq {
+			t.Fatalf("unexpected sequence for %s, exp: %d, actual: %d", name, seq, msg.Seq)
 		}
 	}
 
-	if err := bq.Close(); err != nil {
-		t.Fatalf("error in closing bigqueue :: %v", err)
+	if pos, err := bq.EnqueueWithPosition([]byte("elem")); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	} else if pos.Seq() != 10 {
+		t.Fatalf("unexpected sequence of new element, exp: 10, actual: %d", pos.Seq())
 	}
 }
 
-func TestCopyConsumerReadWriteCornerCases(t *testing.T) {
+func TestMetadataMigrateFailure(t *testing.T) {
 	t.Parallel()
 
 	testDir := t.TempDir()
@@ -892,102 +2156,72 @@ func TestCopyConsumerReadWriteCornerCases(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unable to get BigQueue: %v", err)
 	}
-
-	for i := 1; i < 10; i++ {
-		msgLength := i*arenaSize/2 - 8
-		if i == 5 {
-			msgLength -= 8
-		}
-		msg := bytes.Repeat([]byte("a"), msgLength)
-		if err := bq.Enqueue(msg); err != nil {
+	for i := range 10 {
+		if err := bq.Enqueue(bytes.Repeat([]byte(strconv.Itoa(i)), arenaSize/3)); err != nil {
 			t.Fatalf("enqueue failed :: %v", err)
 		}
-
-		if bq.IsEmpty() {
-			t.Fatalf("BigQueue should not be empty")
-		}
-
-		if err := bq.Close(); err != nil {
-			t.Fatalf("error in closing bigqueue :: %v", err)
-		}
-		if bqTemp, err := NewMmapQueue(testDir, SetArenaSize(arenaSize)); err != nil {
-			t.Fatalf("unable to get BigQueue: %v", err)
-		} else {
-			bq = bqTemp
-		}
-
-		if poppedMsg, err := bq.Dequeue(); err != nil {
-			t.Fatalf("unable to dequeue :: %v", err)
-		} else if !bytes.Equal(msg, poppedMsg) {
-			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
-		}
-
-		if !bq.IsEmpty() {
-			t.Fatalf("BigQueue should be empty")
-		}
-
-		lastC, err := bq.NewConsumer("consumer" + strconv.FormatInt(int64(i-1), 10))
-		if err != nil {
-			t.Fatalf("error in creating a consumer :: %v", err)
-		}
-		cur, err := bq.FromConsumer("consumer"+strconv.FormatInt(int64(i), 10), lastC)
-		if err != nil {
-			t.Fatalf("error in creating a consumer :: %v", err)
-		}
-
-		if cur.IsEmpty() {
-			t.Fatalf("BigQueue should not be empty for consumer")
-		}
-
-		for j := 1; j <= i; j++ {
-			c, err := bq.NewConsumer("consumer" + strconv.FormatInt(int64(j), 10))
-			if err != nil {
-				t.Fatalf("error in creating a consumer :: %v", err)
-			}
-
-			if poppedMsg, err := c.DequeueString(); err != nil {
-				t.Fatalf("unable to dequeue from consumer :: %v", err)
-			} else if string(msg) != poppedMsg {
-				t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), poppedMsg)
-			}
-
-			if !c.IsEmpty() {
-				t.Fatalf("BigQueue should be empty")
-			}
-		}
 	}
-
+	if _, err := bq.NewConsumer("consumer"); err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
 	if err := bq.Close(); err != nil {
 		t.Fatalf("error in closing bigqueue :: %v", err)
 	}
-}
 
-func TestConsumersFromDifferentQueuesErr(t *testing.T) {
-	t.Parallel()
-
-	testDir := t.TempDir()
-	bq, err := NewMmapQueue(testDir)
+	// the migration fails half way, after the consumers are moved,
+	// because the elements cannot be counted without the arena.
+	writeOldMetadata(t, testDir, 2)
+	metaPath := filepath.Join(testDir, cMetadataFileName)
+	old, err := os.ReadFile(metaPath)
 	if err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
+		t.Fatalf("unable to read metadata :: %v", err)
 	}
-
-	c1, err := bq.NewConsumer("consumer1")
-	if err != nil {
-		t.Fatalf("error in creating a consumer :: %v", err)
+	if err := os.Remove(filepath.Join(testDir, "1"+cArenaFileSuffix)); err != nil {
+		t.Fatalf("unable to remove arena :: %v", err)
 	}
 
-	if err := bq.Close(); err != nil {
-		t.Fatalf("error in closing bigqueue :: %v", err)
+	if _, err := NewMmapQueue(testDir, SetArenaSize(arenaSize)); err == nil {
+		t.Fatalf("migration should fail without the arena")
 	}
-
-	if bqTemp, err := NewMmapQueue(testDir); err != nil {
-		t.Fatalf("unable to get BigQueue: %v", err)
-	} else {
-		bq = bqTemp
+	if data, err := os.ReadFile(metaPath); err != nil || !bytes.Equal(old, data) {
+		t.Fatalf("metadata should be left in the older version :: %v", err)
 	}
+	if _, err := os.Stat(filepath.Join(testDir, cMetadataTempFileName)); !os.IsNotExist(err) {
+		t.Fatalf("temporary metadata file should be removed :: %v", err)
+	}
+}
 
-	if _, err := bq.FromConsumer("consumer1", c1); err != ErrDifferentQueues {
-		t.Fatalf("expected consumers from different queues error, returned: %v", err)
+func TestMetadataMigrateV3(t *testing.T) {
+	t.Parallel()
+
+	for _, version := range []int{3, 4} {
+		testDir := t.TempDir()
+		bq, err := NewMmapQueue(testDir)
+		if err != nil {
+			t.Fatalf("unable to get BigQueue: %v", err)
+		}
+		if err := bq.EnqueueString("elem"); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+
+		writeOldMetadata(t, testDir, version)
+		bq, err = NewMmapQueue(testDir)
+		if err != nil {
+			t.Fatalf("unable to get BigQueue: %v", err)
+		}
+
+		if bq.md.getVersion() != cMetadataVersion {
+			t.Fatalf("metadata should be migrated, version: %v", bq.md.getVersion())
+		}
+		if msg, err := bq.DequeueString(); err != nil || msg != "elem" {
+			t.Fatalf("unable to dequeue after migration, dq: %s :: %v", msg, err)
+		}
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
 	}
 }
 
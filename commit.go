@@ -0,0 +1,111 @@
+package bigqueue
+
+// consumerConfig stores all the configuration related to a consumer.
+type consumerConfig struct {
+	manualCommit bool
+}
+
+// ConsumerOption is function type that takes a consumerConfig object
+// and sets various consumer parameters in the object.
+type ConsumerOption func(*consumerConfig)
+
+// ConsumerManualCommit returns a ConsumerOption that makes the consumer read ahead
+// without moving its persisted head. All the functions of the consumer move an
+// in-memory cursor instead, which is persisted as the head using Consumer.Commit and
+// reset to the head using Consumer.Rollback. The cursor is shared by all the handles
+// of the consumer and is lost when the queue is closed, elements read after the last
+// commit are then read again. The option has no effect if the consumer already has
+// a cursor, as it was created earlier using this option.
+func ConsumerManualCommit() ConsumerOption {
+	return func(c *consumerConfig) {
+		c.manualCommit = true
+	}
+}
+
+// cursor is the in-memory head of a consumer that commits manually.
+type cursor struct {
+	aid    int
+	offset int
+	seq    uint64
+}
+
+// Commit persists the position of the cursor as the head of the consumer.
+// Commit has no effect on consumers that don't commit manually.
+func (c *Consumer) Commit() error {
+	c.mq.lock.Lock()
+	defer c.mq.lock.Unlock()
+
+	if c.mq.isClosed() {
+		return ErrQueueClosed
+	}
+
+	cur, ok := c.mq.cursors[c.base]
+	if !ok {
+		return nil
+	}
+
+	c.mq.md.putConsumerHead(c.base, cur.aid, cur.offset)
+	c.mq.md.putConsumerSeq(c.base, cur.seq)
+	c.mq.incrMutOps()
+	return nil
+}
+
+// Rollback moves the cursor back to the head of the consumer, hence, all the elements
+// read since the last commit are read again. Rollback has no effect on consumers
+// that don't commit manually.
+func (c *Consumer) Rollback() error {
+	c.mq.lock.Lock()
+	defer c.mq.lock.Unlock()
+
+	if c.mq.isClosed() {
+		return ErrQueueClosed
+	}
+
+	cur, ok := c.mq.cursors[c.base]
+	if !ok {
+		return nil
+	}
+
+	cur.aid, cur.offset = c.mq.md.getConsumerHead(c.base)
+	cur.seq = c.mq.md.getConsumerSeq(c.base)
+	c.mq.notifyEnqueue()
+	return nil
+}
+
+// getConsumerHead returns the cursor of the consumer if it commits
+// manually, otherwise, the head of the consumer stored in the metadata.
+func (q *MmapQueue) getConsumerHead(base int64) (int, int) {
+	if cur, ok := q.cursors[base]; ok {
+		return cur.aid, cur.offset
+	}
+	return q.md.getConsumerHead(base)
+}
+
+// putConsumerHead moves the cursor of the consumer if it commits
+// manually, otherwise, the head of the consumer stored in the metadata.
+func (q *MmapQueue) putConsumerHead(base int64, aid, offset int) {
+	if cur, ok := q.cursors[base]; ok {
+		cur.aid, cur.offset = aid, offset
+		return
+	}
+	q.md.putConsumerHead(base, aid, offset)
+}
+
+// getConsumerSeq returns the sequence number of the cursor of the consumer if it
+// commits manually, otherwise, the sequence number of the head of the consumer.
+func (q *MmapQueue) getConsumerSeq(base int64) uint64 {
+	if cur, ok := q.cursors[base]; ok {
+		return cur.seq
+	}
+	return q.md.getConsumerSeq(base)
+}
+
+// putConsumerSeq stores the sequence number of the cursor of the consumer if it
+// commits manually, otherwise, the sequence number of the head of the consumer.
+func (q *MmapQueue) putConsumerSeq(base int64, seq uint64) {
+	if cur, ok := q.cursors[base]; ok {
+		cur.seq = seq
+		return
+	}
+	q.md.putConsumerSeq(base, seq)
+}
@@ -0,0 +1,146 @@
+package bigqueue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConsumerCommit(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	for i := range 5 {
+		if err := bq.EnqueueString(strconv.Itoa(i)); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
+
+	c, err := bq.NewConsumer("consumer", ConsumerManualCommit())
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	dequeue := func(c *Consumer, want ...int) {
+		t.Helper()
+
+		for _, i := range want {
+			if msg, err := c.DequeueString(); err != nil || msg != strconv.Itoa(i) {
+				t.Fatalf("expected element %d, got: %s :: %v", i, msg, err)
+			}
+		}
+	}
+
+	// the persisted head only moves upon commit
+	dequeue(c, 0, 1)
+	if seq := bq.md.getConsumerSeq(c.base); seq != 0 {
+		t.Fatalf("head should not move before commit, seq: %d", seq)
+	}
+	if err := c.Commit(); err != nil {
+		t.Fatalf("commit failed :: %v", err)
+	}
+	if seq := bq.md.getConsumerSeq(c.base); seq != 2 {
+		t.Fatalf("head should move upon commit, seq: %d", seq)
+	}
+
+	// rollback returns the cursor to the last commit
+	dequeue(c, 2, 3)
+	if err := c.Rollback(); err != nil {
+		t.Fatalf("rollback failed :: %v", err)
+	}
+	dequeue(c, 2)
+
+	// all the handles of the consumer share the cursor
+	other, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	dequeue(other, 3, 4)
+	if !c.IsEmpty() {
+		t.Fatalf("consumer should be empty")
+	}
+
+	// other consumers are not affected
+	plain, err := bq.NewConsumer("plain")
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	dequeue(plain, 0)
+	if err := plain.Commit(); err != nil {
+		t.Fatalf("commit failed :: %v", err)
+	}
+	if seq := bq.md.getConsumerSeq(plain.base); seq != 1 {
+		t.Fatalf("head of consumer should move on dequeue, seq: %d", seq)
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+	if err := c.Commit(); err != ErrQueueClosed {
+		t.Fatalf("expected error: %v, got: %v", ErrQueueClosed, err)
+	}
+
+	// elements read after the last commit are read again upon reopen
+	bq, err = NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+	if c, err = bq.NewConsumer("consumer", ConsumerManualCommit()); err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	dequeue(c, 2, 3, 4)
+}
+
+func TestConsumerRollbackWakesWaiters(t *testing.T) {
+	t.Parallel()
+
+	bq, err := NewMmapQueue(t.TempDir(), SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if err := bq.EnqueueString("elem"); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+	c, err := bq.NewConsumer("consumer", ConsumerManualCommit())
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	if _, err := c.Dequeue(); err != nil {
+		t.Fatalf("dequeue failed :: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		msg, err := c.DequeueWait(ctx)
+		if err == nil && string(msg) != "elem" {
+			err = fmt.Errorf("expected elem, got: %s", msg)
+		}
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := c.Rollback(); err != nil {
+		t.Fatalf("rollback failed :: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("DequeueWait failed :: %v", err)
+	}
+}
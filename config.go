@@ -15,6 +15,8 @@ const (
 	// values chosen arbitrarily
 	cDefaultMutOps      = 1000000
 	cDefaultflushPeriod = time.Second
+
+	cDefaultVisibilityTimeout = 30 * time.Second
 )
 
 var (
@@ -22,6 +24,8 @@ var (
 	ErrTooSmallArenaSize = errors.New("too small arena size")
 	// ErrTooFewInMemArenas is returned when number of arenas allowed in memory < 3.
 	ErrTooFewInMemArenas = errors.New("too few in memory arenas")
+	// ErrInvalidVisibilityTimeout is returned when visibility timeout is not positive.
+	ErrInvalidVisibilityTimeout = errors.New("invalid visibility timeout")
 )
 
 // bqConfig stores all the configuration related to bigqueue.
@@ -30,6 +34,8 @@ type bqConfig struct {
 	maxInMemArenas int
 	flushMutOps    int64
 	flushPeriod    time.Duration
+	expiryHandler  func(Record)
+	visibility     time.Duration
 }
 
 // Option is function type that takes a bqConfig object
@@ -43,6 +49,7 @@ func newConfig() *bqConfig {
 		maxInMemArenas: cMinMaxInMemArenas,
 		flushMutOps:    cDefaultMutOps,
 		flushPeriod:    cDefaultflushPeriod,
+		visibility:     cDefaultVisibilityTimeout,
 	}
 }
 
@@ -105,3 +112,30 @@ func SetPeriodicFlushDuration(flushPeriod time.Duration) Option {
 		return nil
 	}
 }
+
+// SetExpiryHandler returns an Option that sets a function which is called with
+// every expired record, including its data, when the record is dropped, i.e. once
+// the head of a consumer moves past the record, hence, it is called once per consumer.
+// The function is called while the queue is locked, hence, it must not call
+// any method of the queue. See EnqueueWithTTL for details on expiry of records.
+func SetExpiryHandler(fn func(Record)) Option {
+	return func(c *bqConfig) error {
+		c.expiryHandler = fn
+		return nil
+	}
+}
+
+// SetVisibilityTimeout returns an Option that sets the duration for which an element
+// received using Receive stays invisible to further receives of the same consumer.
+// If the element is neither acknowledged nor rejected within that duration, it is
+// delivered again. By default, the visibility timeout is 30 seconds.
+func SetVisibilityTimeout(timeout time.Duration) Option {
+	return func(c *bqConfig) error {
+		if timeout <= 0 {
+			return ErrInvalidVisibilityTimeout
+		}
+
+		c.visibility = timeout
+		return nil
+	}
+}
@@ -1,9 +1,17 @@
 package bigqueue
 
+import (
+	"context"
+	"io"
+	"iter"
+	"time"
+)
+
 // Consumer is a bigqueue consumer that allows reading data from bigqueue.
 // A consumer is represented using just a base offset into the metadata
 type Consumer struct {
 	mq   *MmapQueue
+	name string
 	base int64 // base offset in the metadata file
 }
 
@@ -17,7 +25,152 @@ func (c *Consumer) Dequeue() ([]byte, error) {
 	return c.mq.dequeue(c.base)
 }
 
+// DequeueMsg removes an element from the queue and returns it along with its sequence number.
+func (c *Consumer) DequeueMsg() (Message, error) {
+	return c.mq.dequeueMsg(c.base)
+}
+
+// DequeueRecord removes a record from the queue and returns it. Elements that
+// were not added using EnqueueRecord are returned as a record with just the data.
+func (c *Consumer) DequeueRecord() (Record, error) {
+	return c.mq.dequeueRecord(c.base)
+}
+
+// Receive returns the next element of the queue without removing it, the element
+// is removed once it is acknowledged. Look at MmapQueue.Receive for details.
+func (c *Consumer) Receive() (*Delivery, error) {
+	return c.mq.receive(c.base)
+}
+
+// SeekToTime moves the head of the consumer to the first element enqueued at or
+// after given time, the head may also move backwards. Look at MmapQueue.SeekToTime
+// for details on how precisely elements without timestamp are positioned.
+func (c *Consumer) SeekToTime(t time.Time) error {
+	return c.mq.seekToTime(c.base, t)
+}
+
+// SeekToStart moves the head of the consumer back to the first element of the queue.
+func (c *Consumer) SeekToStart() error {
+	return c.mq.seekToStart(c.base)
+}
+
+// SeekToEnd moves the head of the consumer to the tail of the queue, skipping all the
+// elements in the queue. Only elements enqueued afterwards are dequeued by the consumer.
+func (c *Consumer) SeekToEnd() error {
+	return c.mq.seekToEnd(c.base)
+}
+
+// Seek moves the head of the consumer to the given position, the head may also
+// move backwards. Look at MmapQueue.Seek for details on which positions are valid.
+func (c *Consumer) Seek(pos Position) error {
+	return c.mq.seek(c.base, pos)
+}
+
+// Position returns the position of the head of the consumer.
+func (c *Consumer) Position() (Position, error) {
+	return c.mq.position(c.base)
+}
+
+// DequeueWait removes an element from the queue and returns it. If the queue is
+// empty, it blocks until an element is enqueued. It returns ctx.Err() if the context
+// is done and ErrQueueClosed if the queue is closed while waiting for an element.
+func (c *Consumer) DequeueWait(ctx context.Context) ([]byte, error) {
+	return c.mq.dequeueWait(ctx, c.base)
+}
+
+// DequeueInto removes an element from the queue and copies it into buf,
+// reusing the capacity of buf. It returns buf resliced to the length of the
+// element. If the element is longer than cap(buf), a *BufferTooSmallError
+// is returned carrying the length of the element, and the element stays in the queue.
+func (c *Consumer) DequeueInto(buf []byte) ([]byte, error) {
+	return c.mq.dequeueInto(c.base, buf)
+}
+
+// AppendDequeue removes an element from the queue, appends it to dst and
+// returns the extended slice. dst is grown only if it doesn't have enough
+// spare capacity to hold the element. In case of an error, dst is returned unchanged.
+func (c *Consumer) AppendDequeue(dst []byte) ([]byte, error) {
+	return c.mq.appendDequeue(c.base, dst)
+}
+
+// DequeueFunc calls fn with the element at the head of the queue and removes the
+// element only if fn returns nil. Otherwise, the error returned by fn is returned and
+// the element stays in the queue. If the element is stored in a single arena, msg
+// aliases the memory mapped arena and no copy is made. msg is valid only until fn
+// returns and must not be modified. fn is called while the queue is locked, hence,
+// it must not call any method of the queue.
+func (c *Consumer) DequeueFunc(fn func(msg []byte) error) error {
+	return c.mq.dequeueFunc(c.base, fn)
+}
+
 // DequeueString removes a string element from the queue and returns it.
 func (c *Consumer) DequeueString() (string, error) {
 	return c.mq.dequeueString(c.base)
 }
+
+// Peek returns the element at the head of the queue without removing it.
+func (c *Consumer) Peek() ([]byte, error) {
+	return c.mq.peek(c.base)
+}
+
+// PeekString returns the string element at the head of the queue without removing it.
+func (c *Consumer) PeekString() (string, error) {
+	return c.mq.peekString(c.base)
+}
+
+// PeekN returns up to n elements from the head of the queue without removing them.
+// Fewer than n elements are returned if the queue doesn't have enough elements.
+func (c *Consumer) PeekN(n int) ([][]byte, error) {
+	return c.mq.peekN(c.base, n)
+}
+
+// NextLen returns the length of the element at the head of the
+// queue without removing it or reading its content.
+func (c *Consumer) NextLen() (int, error) {
+	return c.mq.nextLen(c.base)
+}
+
+// BacklogBytes returns the number of bytes in the queue from the head of
+// the consumer to the tail, including the length prefix of each element.
+func (c *Consumer) BacklogBytes() int {
+	return c.mq.backlogBytes(c.base)
+}
+
+// DequeueBatch removes up to maxMsgs elements from the queue, as long as their
+// total length doesn't exceed maxBytes, and returns them. The elements are read
+// while holding the lock once and the head of the queue is updated only once.
+// At least one element is returned if the queue is not empty, even if its length
+// exceeds maxBytes. If maxBytes <= 0, total length of elements is not limited.
+func (c *Consumer) DequeueBatch(maxMsgs, maxBytes int) ([][]byte, error) {
+	return c.mq.dequeueBatch(c.base, maxMsgs, maxBytes)
+}
+
+// DequeueReader returns a reader that streams the element at the head of the
+// queue without loading the whole element into memory. The element is removed
+// from the queue when the reader is closed after it has been read until io.EOF.
+// If the reader is closed before that or is abandoned, the element stays in the queue.
+func (c *Consumer) DequeueReader() (io.ReadCloser, error) {
+	return c.mq.dequeueStream(c.base)
+}
+
+// Messages returns an iterator that removes elements from the queue one at a time
+// and yields them until the queue is empty. Each element is removed from the queue
+// before it is yielded, hence, breaking out of the loop early leaves all the elements
+// that have not been yielded yet in the queue. The context is checked before removing
+// every element and ctx.Err() is yielded once the context is done. Any other error
+// is also yielded and ends the iteration. To keep waiting for new elements once the
+// queue is empty, use DequeueWait.
+func (c *Consumer) Messages(ctx context.Context) iter.Seq2[[]byte, error] {
+	return c.mq.messages(ctx, c.base)
+}
+
+// Subscribe starts a background go routine that reads elements from the queue and
+// sends them on the returned message channel, which is buffered with bufSize elements.
+// The go routine waits for new elements when the queue is empty. It stops once the
+// context is done or the queue is closed, and both channels are closed afterwards.
+// An error, including ErrQueueClosed, is sent on the error channel before it stops.
+// Look at MmapQueue.Subscribe for details on when the head of the consumer is moved.
+func (c *Consumer) Subscribe(ctx context.Context, bufSize int,
+	opts ...SubscribeOption) (<-chan Message, <-chan error) {
+	return c.mq.subscribe(ctx, c.base, bufSize, opts...)
+}
@@ -0,0 +1,409 @@
+package bigqueue
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	cDelayedDir        = "delayed"
+	cPromotedFileName  = "promoted.dat"
+	cPromoteRetryDelay = 10 * time.Millisecond
+)
+
+// delayedElem is an element of the delay queue that has not been promoted yet.
+type delayedElem struct {
+	readyAt int64 // in nanoseconds
+	seq     uint64
+	aid     int
+	offset  int
+}
+
+// delayHeap orders the delayed elements by their ready time and, for
+// the same ready time, by the order in which they were enqueued.
+type delayHeap []delayedElem
+
+func (h delayHeap) Len() int { return len(h) }
+
+func (h delayHeap) Less(i, j int) bool {
+	if h[i].readyAt != h[j].readyAt {
+		return h[i].readyAt < h[j].readyAt
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h delayHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *delayHeap) Push(x any) { *h = append(*h, x.(delayedElem)) }
+
+func (h *delayHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// delayQueue stores the elements that are not ready to be dequeued yet. The
+// elements are stored as records in a queue of their own, in the order they are
+// enqueued, along with their ready time. Once an element is ready, it is promoted,
+// i.e. copied to the tail of the queue with the promotion time as its timestamp. Elements are promoted
+// out of order, the head of the delay queue moves past the elements that are
+// promoted, and the sequence numbers of the elements promoted ahead of the head
+// are stored in the promoted file.
+type delayQueue struct {
+	q        *MmapQueue
+	pending  delayHeap
+	promoted map[uint64]struct{}
+	fd       *os.File
+	wake     chan struct{} // signals the promoting go routine that pending has changed
+}
+
+// newDelayQueue opens or creates the delay queue in given directory
+// and loads all the elements that have not been promoted yet.
+func newDelayQueue(dir string, conf *bqConfig) (*delayQueue, error) {
+	complete := false
+
+	if err := os.MkdirAll(dir, cFilePerm); err != nil {
+		return nil, fmt.Errorf("error in creating delay queue directory :: %w", err)
+	}
+
+	// the delay queue is only flushed after the queue, see flush.
+	q, err := NewMmapQueue(dir, SetArenaSize(conf.arenaSize), SetMaxInMemArenas(conf.maxInMemArenas),
+		SetPeriodicFlushOps(0), SetPeriodicFlushDuration(0))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if !complete {
+			_ = q.Close()
+		}
+	}()
+
+	fd, err := os.OpenFile(filepath.Join(dir, cPromotedFileName), os.O_CREATE|os.O_RDWR, cFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("error in creating/opening promoted file :: %w", err)
+	}
+	defer func() {
+		if !complete {
+			_ = fd.Close()
+		}
+	}()
+
+	data, err := io.ReadAll(fd)
+	if err != nil {
+		return nil, fmt.Errorf("error in reading promoted file :: %w", err)
+	}
+
+	dq := &delayQueue{q: q, promoted: make(map[uint64]struct{}), fd: fd, wake: make(chan struct{}, 1)}
+	for ; len(data) >= cInt64Size; data = data[cInt64Size:] {
+		dq.promoted[binary.LittleEndian.Uint64(data)] = struct{}{}
+	}
+
+	if err := dq.load(); err != nil {
+		return nil, err
+	}
+
+	complete = true
+	return dq, nil
+}
+
+// load walks the delay queue from its head to its tail and adds all
+// the elements that have not been promoted yet to the pending elements.
+func (dq *delayQueue) load() error {
+	dq.q.lock.Lock()
+	defer dq.q.lock.Unlock()
+
+	aid, offset := dq.q.md.getConsumerHead(dq.q.dc)
+	seq := dq.q.md.getConsumerSeq(dq.q.dc)
+	tailAid, tailOffset := dq.q.md.getTail()
+	for ; aid != tailAid || offset != tailOffset; seq++ {
+		r, dataAid, dataOffset, length, err := dq.q.readEnvelope(aid, offset)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := dq.promoted[seq]; !ok {
+			e := delayedElem{readyAt: r.ReadyAt.UnixNano(), seq: seq, aid: aid, offset: offset}
+			dq.pending = append(dq.pending, e)
+		}
+		aid, offset = dq.q.advance(dataAid, dataOffset, length)
+	}
+
+	// promoted elements behind the head were written to the promoted file before
+	// the head was moved past them, the file is rewritten without them.
+	for s := range dq.promoted {
+		if s < dq.q.md.getConsumerSeq(dq.q.dc) {
+			delete(dq.promoted, s)
+		}
+	}
+
+	heap.Init(&dq.pending)
+	return dq.writePromoted()
+}
+
+// add stores the element in the delay queue until the given time.
+func (dq *delayQueue) add(message []byte, t time.Time) error {
+	dq.q.wlock.Lock()
+	defer dq.q.wlock.Unlock()
+	dq.q.lock.Lock()
+	defer dq.q.lock.Unlock()
+
+	pos, err := dq.q.enqueueRecord(Record{ReadyAt: t, Data: message})
+	if err != nil {
+		return err
+	}
+
+	heap.Push(&dq.pending, delayedElem{readyAt: t.UnixNano(), seq: pos.seq, aid: pos.aid, offset: pos.offset})
+	select {
+	case dq.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// read reads the record of given pending element from the delay queue.
+func (dq *delayQueue) read(e delayedElem) (Record, error) {
+	dq.q.lock.Lock()
+	defer dq.q.lock.Unlock()
+
+	r, _, _, err := dq.q.readFullRecord(e.aid, e.offset)
+	return r, err
+}
+
+// remove removes the first pending element once it has been promoted. The head
+// of the delay queue moves past all the promoted elements that it reaches.
+func (dq *delayQueue) remove() error {
+	e, _ := heap.Pop(&dq.pending).(delayedElem)
+
+	dq.q.lock.Lock()
+	defer dq.q.lock.Unlock()
+
+	base := dq.q.dc
+	if e.seq != dq.q.md.getConsumerSeq(base) {
+		dq.promoted[e.seq] = struct{}{}
+		var data [cInt64Size]byte
+		binary.LittleEndian.PutUint64(data[:], e.seq)
+		if _, err := dq.fd.WriteAt(data[:], int64((len(dq.promoted)-1)*cInt64Size)); err != nil {
+			return fmt.Errorf("error in writing promoted file :: %w", err)
+		}
+		return nil
+	}
+
+	n := 0
+	aid, offset := dq.q.md.getConsumerHead(base)
+	tailAid, tailOffset := dq.q.md.getTail()
+	for seq := e.seq; aid != tailAid || offset != tailOffset; seq++ {
+		if _, ok := dq.promoted[seq]; !ok && seq != e.seq {
+			break
+		}
+		delete(dq.promoted, seq)
+
+		dataAid, dataOffset, length, err := dq.q.readLength(aid, offset)
+		if err != nil {
+			return err
+		}
+		aid, offset = dq.q.advance(dataAid, dataOffset, length)
+		n++
+	}
+
+	dq.q.moveHead(base, aid, offset, n)
+	if n > 1 {
+		return dq.writePromoted()
+	}
+	return nil
+}
+
+// writePromoted rewrites the promoted file with the sequence numbers of
+// all the elements promoted ahead of the head of the delay queue.
+func (dq *delayQueue) writePromoted() error {
+	data := make([]byte, 0, len(dq.promoted)*cInt64Size)
+	for seq := range dq.promoted {
+		data = binary.LittleEndian.AppendUint64(data, seq)
+	}
+
+	if _, err := dq.fd.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("error in writing promoted file :: %w", err)
+	}
+	if err := dq.fd.Truncate(int64(len(data))); err != nil {
+		return fmt.Errorf("error in truncating promoted file :: %w", err)
+	}
+	return nil
+}
+
+// flush writes the delay queue on to disk.
+func (dq *delayQueue) flush() error {
+	if err := dq.q.Flush(); err != nil {
+		return err
+	}
+
+	if err := dq.fd.Sync(); err != nil {
+		return fmt.Errorf("error in syncing promoted file :: %w", err)
+	}
+	return nil
+}
+
+// close closes the delay queue.
+func (dq *delayQueue) close() error {
+	var retErr error
+	if err := dq.q.Close(); err != nil {
+		retErr = err
+	}
+
+	if err := dq.fd.Close(); err != nil {
+		retErr = fmt.Errorf("error in closing promoted file :: %w", err)
+	}
+	return retErr
+}
+
+// EnqueueAt adds a new element to the queue that becomes visible to consumers
+// only once the given time has arrived. Until then, the element is kept in a
+// delay queue in the delayed directory inside the queue directory, hence, it
+// survives reopening the queue. Once ready, elements are moved to the tail of the
+// queue in the order of their ready time, as a record with the time it was moved as
+// its timestamp and with the ready time as ReadyAt. An element may be moved twice
+// if the process crashes meanwhile.
+// If the given time has already passed, the element is enqueued right away.
+func (q *MmapQueue) EnqueueAt(message []byte, t time.Time) error {
+	q.wlock.Lock()
+	defer q.wlock.Unlock()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	// ready elements are promoted first so that they stay ahead of this element.
+	now := time.Now()
+	if !t.After(now) {
+		if _, err := q.promoteReady(now); err != nil {
+			return err
+		}
+
+		_, err := q.enqueueRecord(Record{ReadyAt: t, Data: message})
+		return err
+	}
+
+	if q.dq == nil {
+		dq, err := newDelayQueue(filepath.Join(q.dir, cDelayedDir), q.conf)
+		if err != nil {
+			return err
+		}
+		q.startPromoting(dq)
+	}
+
+	if err := q.dq.add(message, t); err != nil {
+		return err
+	}
+
+	q.incrMutOps()
+	return nil
+}
+
+// EnqueueAfter adds a new element to the queue that becomes visible to
+// consumers only once the given duration has elapsed, see EnqueueAt.
+func (q *MmapQueue) EnqueueAfter(message []byte, d time.Duration) error {
+	return q.EnqueueAt(message, time.Now().Add(d))
+}
+
+// Delayed returns the number of elements that are not ready to be dequeued yet.
+func (q *MmapQueue) Delayed() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.dq == nil {
+		return 0
+	}
+	return q.dq.pending.Len()
+}
+
+// startPromoting starts the go routine that promotes the elements of the delay
+// queue once they are ready. It must be called with the lock held, see subscribe.
+func (q *MmapQueue) startPromoting(dq *delayQueue) {
+	q.dq = dq
+	q.wg.Add(1)
+	go q.promoteDelayed()
+}
+
+// promoteDelayed promotes elements of the delay queue as they become ready.
+func (q *MmapQueue) promoteDelayed() {
+	defer q.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		// a MessageWriter or a Reservation may hold the write lock
+		// for a long time, promoting is retried instead of waiting.
+		next := time.Now().Add(cPromoteRetryDelay)
+		if q.wlock.TryLock() {
+			q.lock.Lock()
+			if q.isClosed() {
+				q.lock.Unlock()
+				q.wlock.Unlock()
+				return
+			}
+
+			// errors are retried, the element stays in the delay queue.
+			if readyAt, err := q.promoteReady(time.Now()); err == nil {
+				next = readyAt
+			}
+			q.lock.Unlock()
+			q.wlock.Unlock()
+		}
+
+		var ready <-chan time.Time
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+			ready = timer.C
+		}
+
+		select {
+		case <-q.quit:
+			timer.Stop()
+			return
+		case <-q.dq.wake:
+		case <-ready:
+		}
+		timer.Stop()
+	}
+}
+
+// promoteReady moves all the elements of the delay queue that are ready at given
+// time to the tail of the queue. It returns the ready time of the next element
+// that is not ready yet, which is zero if there is no such element.
+func (q *MmapQueue) promoteReady(now time.Time) (time.Time, error) {
+	if q.dq == nil {
+		return time.Time{}, nil
+	}
+
+	for q.dq.pending.Len() > 0 {
+		e := q.dq.pending[0]
+		if e.readyAt > now.UnixNano() {
+			return time.Unix(0, e.readyAt), nil
+		}
+
+		r, err := q.dq.read(e)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		// timestamps of the queue must be increasing, see SeekToTime,
+		// hence, the promotion time is used as the timestamp.
+		r.Timestamp = time.Time{}
+
+		if _, err := q.enqueueRecord(r); err != nil {
+			return time.Time{}, err
+		}
+
+		if err := q.dq.remove(); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return time.Time{}, nil
+}
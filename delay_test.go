@@ -0,0 +1,165 @@
+package bigqueue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnqueueAt(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if err := bq.Enqueue([]byte("a")); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+	start := time.Now()
+	if err := bq.EnqueueAfter([]byte("late"), 300*time.Millisecond); err != nil {
+		t.Fatalf("EnqueueAfter failed :: %v", err)
+	}
+	if err := bq.EnqueueAfter([]byte("early"), 100*time.Millisecond); err != nil {
+		t.Fatalf("EnqueueAfter failed :: %v", err)
+	}
+	if err := bq.EnqueueAt([]byte("past"), start.Add(-time.Second)); err != nil {
+		t.Fatalf("EnqueueAt failed :: %v", err)
+	}
+
+	// elements with a ready time in the past are enqueued right away
+	for _, want := range []string{"a", "past"} {
+		if msg, err := bq.Dequeue(); err != nil || string(msg) != want {
+			t.Fatalf("expected %s, got: %s :: %v", want, msg, err)
+		}
+	}
+	if !bq.IsEmpty() || bq.Delayed() != 2 {
+		t.Fatalf("delayed elements should not be visible, delayed: %d", bq.Delayed())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, want := range []struct {
+		data  string
+		delay time.Duration
+	}{{"early", 100 * time.Millisecond}, {"late", 300 * time.Millisecond}} {
+		if msg, err := bq.DequeueWait(ctx); err != nil || string(msg) != want.data {
+			t.Fatalf("expected %s, got: %s :: %v", want.data, msg, err)
+		}
+		if time.Since(start) < want.delay {
+			t.Fatalf("%s delivered too early", want.data)
+		}
+	}
+	if !bq.IsEmpty() || bq.Delayed() != 0 {
+		t.Fatalf("all the delayed elements should be delivered, delayed: %d", bq.Delayed())
+	}
+
+	// head of the delay queue moves past the elements promoted out of order
+	if !bq.dq.q.isEmpty(bq.dq.q.dc) || len(bq.dq.promoted) != 0 {
+		t.Fatalf("delay queue should be empty, promoted: %d", len(bq.dq.promoted))
+	}
+	if info, err := os.Stat(filepath.Join(testDir, cDelayedDir, cPromotedFileName)); err != nil || info.Size() != 0 {
+		t.Fatalf("promoted file should be empty :: %v", err)
+	}
+}
+
+func TestEnqueueAtReopen(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+
+	delays := []struct {
+		data  string
+		delay time.Duration
+	}{{"x", time.Hour}, {"y", 50 * time.Millisecond}, {"z", time.Hour}, {"w", time.Second}}
+	for _, d := range delays {
+		if err := bq.EnqueueAfter([]byte(d.data), d.delay); err != nil {
+			t.Fatalf("EnqueueAfter failed :: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if msg, err := bq.DequeueWait(ctx); err != nil || string(msg) != "y" {
+		t.Fatalf("expected y, got: %s :: %v", msg, err)
+	}
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	// delayed elements survive a restore as well
+	restoreDir := t.TempDir()
+	if err := Restore(testDir, restoreDir, RestoreVerifyRecords()); err != nil {
+		t.Fatalf("unable to restore queue :: %v", err)
+	}
+
+	for _, dir := range []string{testDir, restoreDir} {
+		bq, err := NewMmapQueue(dir, SetArenaSize(8*1024))
+		if err != nil {
+			t.Fatalf("unable to get BigQueue :: %v", err)
+		}
+
+		// promoted elements are not delivered again, y would be delivered first
+		if msg, err := bq.DequeueWait(ctx); err != nil || string(msg) != "w" {
+			t.Fatalf("expected w, got: %s :: %v", msg, err)
+		}
+		if bq.Delayed() != 2 || !bq.IsEmpty() {
+			t.Fatalf("elements delayed by an hour should stay delayed, delayed: %d", bq.Delayed())
+		}
+
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}
+}
+
+func TestEnqueueAtSeekToTime(t *testing.T) {
+	t.Parallel()
+
+	bq, err := NewMmapQueue(t.TempDir(), SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if err := bq.EnqueueRecord(Record{Data: []byte("first")}); err != nil {
+		t.Fatalf("EnqueueRecord failed :: %v", err)
+	}
+	mid := time.Now()
+	readyAt := mid.Add(-time.Hour)
+	if err := bq.EnqueueAt([]byte("retry"), readyAt); err != nil {
+		t.Fatalf("EnqueueAt failed :: %v", err)
+	}
+	if err := bq.EnqueueRecord(Record{Data: []byte("last")}); err != nil {
+		t.Fatalf("EnqueueRecord failed :: %v", err)
+	}
+
+	// the ready time of an element doesn't affect its timestamp
+	if err := bq.SeekToTime(mid); err != nil {
+		t.Fatalf("SeekToTime failed :: %v", err)
+	}
+	r, err := bq.DequeueRecord()
+	if err != nil || string(r.Data) != "retry" || !r.ReadyAt.Equal(readyAt) || r.Timestamp.Before(mid) {
+		t.Fatalf("expected retry, got: %s, ts: %v, ready at: %v :: %v", r.Data, r.Timestamp, r.ReadyAt, err)
+	}
+	if msg, err := bq.DequeueString(); err != nil || msg != "last" {
+		t.Fatalf("expected last, got: %s :: %v", msg, err)
+	}
+}
@@ -1,7 +1,6 @@
 // Package bigqueue provides embedded, fast and persistent queue
-// written in pure Go using memory mapped file. bigqueue is
-// currently not thread safe. To use bigqueue in parallel context,
-// a Write lock needs to be acquired (even for Read APIs).
+// written in pure Go using memory mapped file. bigqueue is thread
+// safe, see the Concurrency section below for the exact contract.
 //
 // Create or open a bigqueue:
 //
@@ -49,20 +48,128 @@
 //
 //	err := bq.EnqueueString("elem")   // size = 2
 //
+// The position of an element in the queue can be retrieved while writing it:
+//
+//	pos, err := bq.EnqueueWithPosition([]byte("elem"))
+//
+// An element can be written from multiple slices without concatenating them first:
+//
+//	err := bq.EnqueueV(header, body)
+//
+// Space for an element can be reserved and written directly into the memory mapped
+// arenas. The element becomes visible only when the reservation is committed:
+//
+//	r, err := bq.Reserve(size)
+//	for _, buf := range r.Bytes() {
+//		// write into buf
+//	}
+//	err = r.Commit()
+//
+// Multiple elements can be written atomically, either all of them become visible or none:
+//
+//	err := bq.EnqueueBatch([][]byte{[]byte("elem1"), []byte("elem2")})
+//
+// Very large elements of known size can be streamed into bigqueue. The element
+// becomes visible only when the writer is closed:
+//
+//	w, err := bq.NewMessageWriter(size)
+//	_, err = io.Copy(w, r)
+//	err = w.Close()
+//
 // Read from bigqueue:
 //
 //	elem, err := bq.Dequeue()
 //
+// Every element gets a sequence number, which can be used to detect duplicate or missing elements:
+//
+//	msg, err := bq.DequeueMsg()
+//	seq, data := msg.Seq, msg.Data
+//
+// Elements can carry a key, a timestamp and string headers, which are stored next to the data:
+//
+//	err := bq.EnqueueRecord(bigqueue.Record{Key: key, Headers: map[string]string{"k": "v"}, Data: data})
+//	record, err := bq.DequeueRecord()
+//
+// Elements can be received and acknowledged once processed, unacknowledged
+// elements are delivered again after the visibility timeout:
+//
+//	delivery, err := consumer.Receive()
+//	err = delivery.Ack()
+//
+// Producers can enqueue elements idempotently, replayed elements are dropped:
+//
+//	producer, err := bq.NewProducer("producer-1")
+//	err = producer.Enqueue(seq, elem)
+//
+// Elements can expire, expired elements are dropped when they reach the head of a consumer:
+//
+//	err := bq.EnqueueWithTTL(elem, 5*time.Minute)
+//
+// Elements can be delayed until a given time, they are invisible to consumers until then:
+//
+//	err := bq.EnqueueAfter(elem, time.Minute)
+//
+// A consumer can read ahead and persist its head only upon commit:
+//
+//	consumer, err := bq.NewConsumer("consumer", bigqueue.ConsumerManualCommit())
+//	err = consumer.Commit()
+//
+// A consumer can be moved to the first element enqueued at or after a given time:
+//
+//	err := consumer.SeekToTime(time.Now().Add(-time.Hour))
+//
+// or to the first element, to the tail, or to a position saved earlier:
+//
+//	err := consumer.SeekToStart()
+//	pos, err := consumer.Position()
+//	err = consumer.Seek(pos)
+//
+// Instead of polling, we can also wait for an element to be enqueued:
+//
+//	elem, err := bq.DequeueWait(ctx)
+//
+// To avoid an allocation for every element, we can read into a buffer that we own:
+//
+//	elem, err := bq.DequeueInto(buf)
+//	buf, err = bq.AppendDequeue(buf[:0])
+//
+// Elements can also be read using iterators. Messages removes each element before yielding
+// it, whereas Scan neither removes elements nor moves the head:
+//
+//	for elem, err := range bq.Messages(ctx) {
+//	}
+//	for pos, elem := range bq.Scan(bigqueue.Position{}) {
+//	}
+//
+// A subscription delivers elements on a channel from a background go routine,
+// which is stopped when the context is done or the queue is closed:
+//
+//	msgs, errs := bq.Subscribe(ctx, 16, bigqueue.SubscribeCommitOnAck())
+//	for msg := range msgs {
+//		err := msg.Ack()
+//	}
+//	err := <-errs
+//
 // we can also read string data from bigqueue:
 //
 //	elem, err := bq.DequeueString()
 //
+// Look at the next elements without removing them from bigqueue:
+//
+//	elem, err := bq.Peek()
+//	elems, err := bq.PeekN(10)
+//
+// Get the length of the next element and the number of bytes left in bigqueue:
+//
+//	n, err := bq.NextLen()
+//	backlog := bq.BacklogBytes()
+//
 // Check whether bigqueue has non zero elements:
 //
 //	isEmpty := bq.IsEmpty()
 //
 // bigqueue allows reading data from bigqueue using consumers similar to Kafka. This allows
-// multiple consumers from reading data at different offsets (not in thread safe manner yet).
+// multiple consumers from reading data at different offsets, from any number of go routines.
 // The offsets of each consumer are persisted on disk and can be retrieved by creating a
 // consumer with the same name. Data will be read from the same offset where it was left off.
 //
@@ -72,13 +179,45 @@
 //	consumer, err := bq.NewConsumer("consumer")
 //
 // We can also copy an existing consumer. This will create a consumer that will have the
-// same offsets into the queue as that of the existing consumer.
+// same offsets into the queue as that of the existing consumer. The existing consumer may
+// come from another handle of the same queue, queues are identified by a persisted ID:
 //
 //	copyConsumer, err := bq.FromConsumer("copyConsumer", consumer)
+//	id := bq.ID()
 //
 // Now, read operations can be performed on the consumer:
 //
 //	isEmpty := consumer.IsEmpty()
 //	elem, err := consumer.Dequeue()
 //	elem, err := consumer.DequeueString()
+//	elem, err := consumer.Peek()
+//
+// A copy of a queue directory, for example from a snapshot or an archive, can be
+// restored into a new directory. Restore validates the metadata, the arena size and
+// the presence of every arena before the queue can be opened:
+//
+//	err := bigqueue.Restore("path/to/snapshot", "path/to/queue", bigqueue.RestoreVerifyRecords())
+//
+// # Concurrency
+//
+// All the methods of a queue and of its consumers, producers, reservations, deliveries
+// and messages are safe to call from multiple go routines. Each element is removed by
+// only one of the go routines sharing a consumer. A queue directory must be opened by
+// only one handle at a time, in one process, as handles don't coordinate with each other.
+//
+// Every operation holds the lock of the queue while it runs. Enqueues also hold a write
+// lock, which a Reservation or a MessageWriter keeps until it is committed, closed or
+// aborted. Other enqueues block meanwhile, dequeues don't.
+//
+// The callback of DequeueFunc and the handler set using SetExpiryHandler are called
+// while the lock of the queue is held. They must return quickly and must not call any
+// method of the queue, or of its consumers, or they deadlock. The lock is not held while
+// the caller processes elements of Scan, Messages or a subscription.
+//
+// Background go routines flush the queue periodically, deliver subscriptions and move
+// delayed elements to the queue. Close first waits for an open Reservation or
+// MessageWriter to be committed, closed or aborted, hence, it must not be called by the
+// go routine that holds one. It then stops the background go routines and wakes up
+// blocked calls, which return ErrQueueClosed. Other methods must not be called once
+// Close has returned.
 package bigqueue
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/grandecola/bigqueue"
 )
@@ -66,4 +68,20 @@ func main() {
 		panic(err)
 	}
 	fmt.Println("consumer2: expected: elem2, dequeued:", elem2)
+
+	// DequeueWait blocks until an element is enqueued instead of polling IsEmpty
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if err := bq.EnqueueString("elem3"); err != nil {
+			panic(err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	elem, err = c2.DequeueWait(ctx)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("consumer2: expected: elem3, dequeued:", string(elem))
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -268,8 +269,7 @@ func writeToFile(oq *bigqueue.MmapQueue, outputPath string) error {
 	defer od.Close()
 
 	w := bufio.NewWriter(od)
-	for !oq.IsEmpty() {
-		v, err := oq.Dequeue()
+	for v, err := range oq.Messages(context.Background()) {
 		if err != nil {
 			return fmt.Errorf("unable to dequeue from bigqueue :: %v", err)
 		}
@@ -0,0 +1,112 @@
+package bigqueue
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// EnqueueWithTTL adds a new element to the tail of the queue that expires once ttl
+// has elapsed. Expired elements are dropped transparently by all the functions that
+// read from the head of a consumer, e.g. Dequeue, Peek and Subscribe, and are passed
+// to the handler set using SetExpiryHandler, if any. Scan still yields them. Until
+// they reach the head of a consumer, expired elements are counted by IsEmpty and
+// BacklogBytes. The element is stored as a record, see EnqueueRecord.
+func (q *MmapQueue) EnqueueWithTTL(message []byte, ttl time.Duration) error {
+	return q.EnqueueRecord(Record{Data: message, ExpiresAt: time.Now().Add(ttl)})
+}
+
+// Expired returns the number of expired elements that have
+// been dropped from the queue since the queue was opened.
+func (q *MmapQueue) Expired() uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.expired
+}
+
+// skippedRecord is an expired record that has been skipped by a consumer
+// but is not dropped yet, as the head of the consumer has not moved past it.
+type skippedRecord struct {
+	seq    uint64
+	aid    int
+	offset int
+}
+
+// dropExpired removes the expired records at the head of the consumer.
+func (q *MmapQueue) dropExpired(base int64) error {
+	headAid, headOffset := q.getConsumerHead(base)
+	aid, offset, n, err := q.skipExpired(base, headAid, headOffset, q.getConsumerSeq(base), true)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		q.moveHead(base, aid, offset, n)
+	}
+	return nil
+}
+
+// skipExpired skips the expired records starting at given position, where the record
+// has sequence number seq, and returns the position of the first record that has not
+// expired along with the number of records skipped. If mark is true, skipped records
+// are marked for the consumer and are dropped once its head moves past them, hence,
+// a record skipped multiple times, e.g. by a restarted subscription, is dropped once.
+func (q *MmapQueue) skipExpired(base int64, aid, offset int, seq uint64, mark bool) (int, int, int, error) {
+	now := time.Now()
+	tailAid, tailOffset := q.md.getTail()
+	n := 0
+	for aid != tailAid || offset != tailOffset {
+		r, dataAid, dataOffset, length, err := q.readEnvelope(aid, offset)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if r.ExpiresAt.IsZero() || r.ExpiresAt.After(now) {
+			break
+		}
+
+		if mark {
+			q.markSkipped(base, skippedRecord{seq: seq + uint64(n), aid: aid, offset: offset})
+		}
+		aid, offset = q.advance(dataAid, dataOffset, length)
+		n++
+	}
+
+	return aid, offset, n, nil
+}
+
+// markSkipped stores the skipped record of the consumer, unless it is
+// already stored. Skipped records of a consumer are ordered by seq.
+func (q *MmapQueue) markSkipped(base int64, r skippedRecord) {
+	records := q.skipped[base]
+	i, ok := slices.BinarySearchFunc(records, r.seq, func(r skippedRecord, seq uint64) int {
+		return cmp.Compare(r.seq, seq)
+	})
+	if !ok {
+		q.skipped[base] = slices.Insert(records, i, r)
+	}
+}
+
+// dropSkipped drops the skipped records that the head of the consumer has moved past.
+// Dropped records are counted and passed to the expiry handler, if any. A record that
+// cannot be read is still dropped, it is only not passed to the handler.
+func (q *MmapQueue) dropSkipped(base int64) {
+	records := q.skipped[base]
+	headSeq := q.getConsumerSeq(base)
+	n := 0
+	for ; n < len(records) && records[n].seq < headSeq; n++ {
+		if q.conf.expiryHandler == nil {
+			continue
+		}
+		if r, _, _, err := q.readFullRecord(records[n].aid, records[n].offset); err == nil {
+			q.conf.expiryHandler(r)
+		}
+	}
+
+	q.expired += uint64(n)
+	if n == len(records) {
+		delete(q.skipped, base)
+	} else {
+		q.skipped[base] = records[n:]
+	}
+}
@@ -0,0 +1,191 @@
+package bigqueue
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEnqueueWithTTL(t *testing.T) {
+	t.Parallel()
+
+	var dropped []Record
+	testDir := t.TempDir()
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize),
+		SetExpiryHandler(func(r Record) { dropped = append(dropped, r) }))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	// the last expired element spans arenas
+	big := bytes.Repeat([]byte("e"), arenaSize)
+	enqueue := []func() error{
+		func() error { return bq.Enqueue([]byte("a")) },
+		func() error { return bq.EnqueueWithTTL([]byte("expired"), -time.Second) },
+		func() error { return bq.EnqueueWithTTL([]byte("live"), time.Hour) },
+		func() error { return bq.EnqueueRecord(Record{Data: []byte("expired"), ExpiresAt: time.Unix(1, 0)}) },
+		func() error { return bq.EnqueueWithTTL(big, 0) },
+		func() error { return bq.Enqueue([]byte("b")) },
+	}
+	for _, fn := range enqueue {
+		if err := fn(); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
+
+	// PeekN skips expired elements without dropping them
+	c, err := bq.NewConsumer("peek")
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	if err := c.SeekToTime(time.Time{}); err != nil {
+		t.Fatalf("SeekToTime failed :: %v", err)
+	}
+	if msgs, err := bq.PeekN(10); err != nil {
+		t.Fatalf("PeekN failed :: %v", err)
+	} else if !slices.EqualFunc(msgs, [][]byte{[]byte("a"), []byte("live"), []byte("b")}, bytes.Equal) {
+		t.Fatalf("PeekN should skip expired elements, returned: %q", msgs)
+	}
+	if bq.Expired() != 0 || len(dropped) != 0 {
+		t.Fatalf("PeekN should not drop expired elements past the head, dropped: %d", bq.Expired())
+	}
+
+	if msg, err := bq.Dequeue(); err != nil || string(msg) != "a" {
+		t.Fatalf("expected a, got: %s :: %v", msg, err)
+	}
+	if msg, err := bq.Peek(); err != nil || string(msg) != "live" {
+		t.Fatalf("expected live, got: %s :: %v", msg, err)
+	}
+	if bq.Expired() != 1 || len(dropped) != 1 || string(dropped[0].Data) != "expired" {
+		t.Fatalf("expired element should be dropped and handled, dropped: %d", bq.Expired())
+	}
+	if msg, err := bq.Dequeue(); err != nil || string(msg) != "live" {
+		t.Fatalf("expected live, got: %s :: %v", msg, err)
+	}
+
+	// sequence numbers account for the dropped elements
+	msg, err := bq.DequeueMsg()
+	if err != nil || string(msg.Data) != "b" || msg.Seq != 5 {
+		t.Fatalf("expected b with seq 5, got: %s, seq: %d :: %v", msg.Data, msg.Seq, err)
+	}
+	if bq.Expired() != 3 || len(dropped) != 3 || !bytes.Equal(dropped[2].Data, big) {
+		t.Fatalf("all expired elements should be dropped and handled, dropped: %d", bq.Expired())
+	}
+	if !bq.IsEmpty() {
+		t.Fatalf("queue should be empty")
+	}
+
+	// batches drop expired elements in between
+	if msgs, err := c.DequeueBatch(10, 0); err != nil {
+		t.Fatalf("DequeueBatch failed :: %v", err)
+	} else if !slices.EqualFunc(msgs, [][]byte{[]byte("a"), []byte("live"), []byte("b")}, bytes.Equal) {
+		t.Fatalf("DequeueBatch should skip expired elements, returned: %q", msgs)
+	}
+	if !c.IsEmpty() || bq.Expired() != 6 {
+		t.Fatalf("consumer should be empty after dropping expired elements, dropped: %d", bq.Expired())
+	}
+
+	// subscriptions skip expired elements as well
+	if err := c.SeekToTime(time.Time{}); err != nil {
+		t.Fatalf("SeekToTime failed :: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msgs, _ := c.Subscribe(ctx, 0, SubscribeCommitOnAck())
+	wantMsgs := []Message{{Seq: 0, Data: []byte("a")}, {Seq: 2, Data: []byte("live")}, {Seq: 5, Data: []byte("b")}}
+	for _, want := range wantMsgs {
+		msg := <-msgs
+		if msg.Seq != want.Seq || !bytes.Equal(msg.Data, want.Data) {
+			t.Fatalf("expected %s with seq %d, got: %s, seq: %d", want.Data, want.Seq, msg.Data, msg.Seq)
+		}
+		if err := msg.Ack(); err != nil {
+			t.Fatalf("Ack failed :: %v", err)
+		}
+	}
+	if !c.IsEmpty() {
+		t.Fatalf("consumer should be empty after acknowledging all messages")
+	}
+}
+
+func TestExpiredDroppedOnce(t *testing.T) {
+	t.Parallel()
+
+	var dropped []Record
+	bq, err := NewMmapQueue(t.TempDir(), SetArenaSize(8*1024),
+		SetExpiryHandler(func(r Record) { dropped = append(dropped, r) }))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if err := bq.EnqueueString("a"); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+	if err := bq.EnqueueWithTTL([]byte("expired"), -time.Second); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+	if err := bq.EnqueueString("b"); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	// restarted subscriptions skip the expired element again without dropping it
+	var last Message
+	for range 2 {
+		ctx, cancel := context.WithCancel(context.Background())
+		msgs, errs := bq.Subscribe(ctx, 0, SubscribeCommitOnAck())
+		for _, want := range []string{"a", "b"} {
+			if last = <-msgs; string(last.Data) != want {
+				t.Fatalf("expected %s, got: %s", want, last.Data)
+			}
+		}
+		cancel()
+		if err := <-errs; err != nil {
+			t.Fatalf("no error expected upon cancel, returned: %v", err)
+		}
+	}
+
+	bq.lock.Lock()
+	expired, handled := bq.expired, len(dropped)
+	bq.lock.Unlock()
+	if expired != 0 || handled != 0 {
+		t.Fatalf("expired element ahead of the head should not be dropped, dropped: %d", expired)
+	}
+
+	// the expired element is dropped once the head moves past it
+	if err := last.Ack(); err != nil {
+		t.Fatalf("Ack failed :: %v", err)
+	}
+	if bq.Expired() != 1 || len(dropped) != 1 || string(dropped[0].Data) != "expired" {
+		t.Fatalf("expired element should be dropped and handled once, dropped: %d", bq.Expired())
+	}
+
+	// received elements behave the same way
+	c, err := bq.NewConsumer("receive")
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	da, err := c.Receive()
+	if err != nil {
+		t.Fatalf("Receive failed :: %v", err)
+	}
+	db, err := c.Receive()
+	if err != nil || string(db.Data) != "b" || bq.Expired() != 1 {
+		t.Fatalf("expected b without dropping the expired element, dropped: %d :: %v", bq.Expired(), err)
+	}
+	ack(t, da, db)
+	if bq.Expired() != 2 || len(dropped) != 2 {
+		t.Fatalf("expired element should be dropped once the head moves past it, dropped: %d", bq.Expired())
+	}
+}
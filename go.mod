@@ -1,5 +1,3 @@
 module github.com/grandecola/bigqueue
 
-require github.com/grandecola/mmap v0.7.0
-
 go 1.23
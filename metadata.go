@@ -1,22 +1,29 @@
 package bigqueue
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
-
-	"github.com/grandecola/mmap"
 )
 
 const (
-	cMetadataVersion  = 1
+	cMetadataVersion  = 5
 	cMetadataFileName = "metadata.dat"
 
+	// cMetadataTempFileName is the copy of the metadata file that is migrated.
+	cMetadataTempFileName = "metadata.dat.tmp"
+
 	// size of file without any consumer information.
-	cMetadataSize = 56
+	cMetadataSize = 80
+
+	// cProducerFlag is set in the name length of the entries of producers.
+	cProducerFlag = 1 << 63
 )
 
 var (
@@ -24,10 +31,19 @@ var (
 	ErrIncompatibleVersion = errors.New("incompatible format of the code and data")
 )
 
+// cMigrations upgrades metadata stored in an older format version to the next version.
+var cMigrations = map[int]func(*metadata) error{
+	1: migrateV1,
+	2: migrateV2,
+	3: migrateV3,
+	4: migrateV4,
+}
+
 // metadata stores head, tail and config parameters for a bigqueue.
 type metadata struct {
-	aa   *mmap.File
+	aa   *arena
 	co   map[string]int64
+	pr   map[string]int64
 	file string
 	size int64
 }
@@ -56,18 +72,26 @@ func loadFile(metaPath string, size int64) (*metadata, error) {
 	md := &metadata{
 		aa:   aa,
 		co:   make(map[string]int64),
+		pr:   make(map[string]int64),
 		file: metaPath,
 		size: size,
 	}
 	if md.getVersion() != cMetadataVersion {
-		return nil, ErrIncompatibleVersion
+		if err := md.migrate(); err != nil {
+			_ = md.aa.Unmap()
+			return nil, err
+		}
 	}
 
 	base := int64(cMetadataSize)
 	for range md.getNumConsumers() {
 		name := md.getConsumerName(base)
-		md.co[name] = base
-		base += int64(len(name)) + 24
+		if md.isProducer(base) {
+			md.pr[name] = base
+		} else {
+			md.co[name] = base
+		}
+		base += int64(len(name)) + 32
 	}
 
 	return md, nil
@@ -83,14 +107,257 @@ func createFile(metaPath string) (*metadata, error) {
 	md := &metadata{
 		aa:   aa,
 		co:   make(map[string]int64),
+		pr:   make(map[string]int64),
 		file: metaPath,
 		size: cMetadataSize,
 	}
 	md.putVersion()
 
+	id, err := newQueueID()
+	if err != nil {
+		return nil, err
+	}
+	md.putID(id)
+
 	return md, nil
 }
 
+// migrate upgrades the metadata to the current format version. Migrations move
+// the consumers around in the file, hence, they run on a copy of the file in the
+// same directory, which replaces the file only once it is fully written to disk.
+// If the process crashes meanwhile, the file is left in its older version.
+func (m *metadata) migrate() error {
+	metaPath := m.file
+	tmpPath := filepath.Join(filepath.Dir(metaPath), cMetadataTempFileName)
+
+	data := make([]byte, m.size)
+	_, _ = m.aa.ReadAt(data, 0)
+	if err := os.WriteFile(tmpPath, data, cFilePerm); err != nil {
+		return fmt.Errorf("error in creating temporary metadata file :: %w", err)
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	aa, err := newArena(tmpPath, int(m.size))
+	if err != nil {
+		return fmt.Errorf("error in creating arena for metadata file :: %w", err)
+	}
+
+	// the metadata of the older version stays mapped until the copy replaces it.
+	old := m.aa
+	m.aa, m.file = aa, tmpPath
+	if err := m.migrateCopy(); err != nil {
+		_ = m.aa.Unmap()
+		m.aa, m.file = old, metaPath
+		return err
+	}
+
+	if err := os.Rename(tmpPath, metaPath); err != nil {
+		_ = m.aa.Unmap()
+		m.aa, m.file = old, metaPath
+		return fmt.Errorf("error in replacing metadata file :: %w", err)
+	}
+
+	m.file = metaPath
+	if err := old.Unmap(); err != nil {
+		return err
+	}
+
+	return syncFile(filepath.Dir(metaPath))
+}
+
+// migrateCopy runs all the migrations from the version of the metadata
+// to the current version and writes the migrated metadata on to disk.
+func (m *metadata) migrateCopy() error {
+	for v := m.getVersion(); v != cMetadataVersion; v = m.getVersion() {
+		migrate, ok := cMigrations[v]
+		if !ok {
+			return ErrIncompatibleVersion
+		}
+
+		if err := migrate(m); err != nil {
+			return err
+		}
+	}
+
+	if err := m.flush(); err != nil {
+		return err
+	}
+
+	// the size of the file changes during migrations, which is only
+	// persisted by syncing the file, not the memory mapped arena.
+	return syncFile(m.file)
+}
+
+// syncFile commits the current contents of the file or directory at given path to disk.
+func syncFile(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error in opening file :: %w", err)
+	}
+	defer func() { _ = fd.Close() }()
+
+	if err := fd.Sync(); err != nil {
+		return fmt.Errorf("error in syncing file :: %w", err)
+	}
+
+	return nil
+}
+
+// migrateV1 upgrades metadata from version 1 to version 2
+// by making space for the queue ID and generating a new ID.
+func migrateV1(m *metadata) error {
+	if err := m.insertAt(56, 16); err != nil {
+		return err
+	}
+
+	id, err := newQueueID()
+	if err != nil {
+		return err
+	}
+
+	m.putID(id)
+	m.aa.WriteUint64At(2, 0)
+	return nil
+}
+
+// migrateV2 upgrades metadata from version 2 to version 3 by making space for the
+// next sequence number and the sequence number of every consumer. The sequence
+// numbers are computed by counting the elements from the head of the queue.
+func migrateV2(m *metadata) error {
+	// consumers of version 2 are stored without sequence number.
+	bases := make([]int64, 0, m.getNumConsumers())
+	base := int64(72)
+	for range m.getNumConsumers() {
+		bases = append(bases, base)
+		base += int64(m.getConsumerLength(base)) + 24
+	}
+
+	// make space in the reverse order so that the offsets of the
+	// consumers that still need to be moved are not changed.
+	for i := len(bases) - 1; i >= 0; i-- {
+		if err := m.insertAt(bases[i]+24, 8); err != nil {
+			return err
+		}
+	}
+	if err := m.insertAt(72, 8); err != nil {
+		return err
+	}
+	for i := range bases {
+		bases[i] += int64(8 * (i + 1))
+	}
+
+	seqs, err := m.countElements(bases)
+	if err != nil {
+		return err
+	}
+	for i, base := range bases {
+		m.putConsumerSeq(base, seqs[i])
+	}
+	m.putNextSeq(seqs[len(bases)])
+
+	m.aa.WriteUint64At(3, 0)
+	return nil
+}
+
+// migrateV3 upgrades metadata from version 3 to version 4. The layout of the
+// metadata doesn't change, version 4 allows records to store an envelope, which
+// older versions cannot read, see EnqueueRecord.
+func migrateV3(m *metadata) error {
+	m.aa.WriteUint64At(4, 0)
+	return nil
+}
+
+// migrateV4 upgrades metadata from version 4 to version 5. The layout of the
+// metadata doesn't change, version 5 stores producers next to the consumers,
+// which older versions cannot read, see NewProducer.
+func migrateV4(m *metadata) error {
+	m.aa.WriteUint64At(5, 0)
+	return nil
+}
+
+// countElements walks the elements of the queue from head to tail using the
+// arena files and returns the number of elements before the head of each of
+// the consumers at the given base offsets, followed by the number of elements
+// before the tail. The arena files are only read, they are not memory mapped.
+func (m *metadata) countElements(bases []int64) ([]uint64, error) {
+	heads := make(map[[2]int][]int, len(bases))
+	for i, base := range bases {
+		aid, offset := m.getConsumerHead(base)
+		heads[[2]int{aid, offset}] = append(heads[[2]int{aid, offset}], i)
+	}
+
+	seqs := make([]uint64, len(bases)+1)
+	arenaSize := m.getArenaSize()
+	tailAid, tailOffset := m.getTail()
+	aid, offset := m.getHead()
+
+	var fd *os.File
+	defer func() {
+		if fd != nil {
+			_ = fd.Close()
+		}
+	}()
+
+	count := m.getHeadSeq()
+	buf := make([]byte, cInt64Size)
+	for {
+		for _, i := range heads[[2]int{aid, offset}] {
+			seqs[i] = count
+		}
+		delete(heads, [2]int{aid, offset})
+		if comparePos(aid, offset, tailAid, tailOffset) >= 0 {
+			break
+		}
+
+		if offset+cInt64Size > arenaSize {
+			aid, offset = aid+1, 0
+		}
+
+		if fd == nil || fd.Name() != m.arenaFile(aid) {
+			if fd != nil {
+				_ = fd.Close()
+			}
+
+			var err error
+			if fd, err = os.Open(m.arenaFile(aid)); err != nil {
+				return nil, fmt.Errorf("error in opening arena file :: %w", err)
+			}
+		}
+
+		if _, err := fd.ReadAt(buf, int64(offset)); err != nil {
+			return nil, fmt.Errorf("error in reading arena file :: %w", err)
+		}
+
+		offset += cInt64Size + int(binary.LittleEndian.Uint64(buf))
+		aid, offset = aid+offset/arenaSize, offset%arenaSize
+		count++
+	}
+
+	if len(heads) != 0 || aid != tailAid || offset != tailOffset {
+		return nil, fmt.Errorf("%w :: consumer or tail is not at an element boundary", ErrCorruptQueue)
+	}
+
+	seqs[len(bases)] = count
+	return seqs, nil
+}
+
+// arenaFile returns the path of the arena file with given arena ID.
+func (m *metadata) arenaFile(aid int) string {
+	return filepath.Join(filepath.Dir(m.file), strconv.Itoa(aid)+cArenaFileSuffix)
+}
+
+// newQueueID generates a random (version 4) UUID for a queue.
+func newQueueID() ([16]byte, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return id, fmt.Errorf("error in generating queue id :: %w", err)
+	}
+
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return id, nil
+}
+
 // getVersion reads the value of data format version.
 //
 //	 <-------- version ------->
@@ -122,6 +389,12 @@ func (m *metadata) getHead() (int, int) {
 	return int(m.aa.ReadUint64At(8)), int(m.aa.ReadUint64At(16))
 }
 
+// getHeadSeq returns the sequence number of the element at the head of the queue.
+// The head of the queue never moves, see putHead, hence, it is the first element.
+func (m *metadata) getHeadSeq() uint64 {
+	return 0
+}
+
 // putHead stores the value of head in the metadata.
 // func (m *metadata) putHead(aid, pos int) {
 // 	m.aa.WriteUint64At(uint64(aid), 8)
@@ -164,7 +437,8 @@ func (m *metadata) putArenaSize(size int) {
 	m.aa.WriteUint64At(uint64(size), 40)
 }
 
-// getNumConsumers reads the value of # of consumers from metadata file.
+// getNumConsumers reads the value of # of consumers, including
+// the producers, from metadata file.
 //
 //	 <---- # of consumers --->
 //	+------------+------------+
@@ -179,13 +453,55 @@ func (m *metadata) putNumConsumers(size int) {
 	m.aa.WriteUint64At(uint64(size), 48)
 }
 
+// getID reads the ID of the queue, a UUID generated when the queue is created.
+//
+//	 <------------------------ queue ID ----------------------->
+//	+------------+------------+------------+------------+
+//	| byte 56-59 | byte 60-63 | byte 64-67 | byte 68-71 |
+//	+------------+------------+------------+------------+
+func (m *metadata) getID() [16]byte {
+	var id [16]byte
+	_, _ = m.aa.ReadAt(id[:], 56)
+	return id
+}
+
+// putID stores the ID of the queue in the metadata.
+func (m *metadata) putID(id [16]byte) {
+	_, _ = m.aa.WriteAt(id[:], 56)
+}
+
+// getNextSeq reads the sequence number of the next element to be enqueued.
+// Sequence numbers start at 0 and are incremented by 1 for every element.
+//
+//	 <---- next sequence ---->
+//	+------------+------------+
+//	| byte 72-75 | byte 76-79 |
+//	+------------+------------+
+func (m *metadata) getNextSeq() uint64 {
+	return m.aa.ReadUint64At(72)
+}
+
+// putNextSeq stores the sequence number of the next element in the metadata.
+func (m *metadata) putNextSeq(seq uint64) {
+	m.aa.WriteUint64At(seq, 72)
+}
+
 /*
  * Now, we store all the consumer information in the metadata file.
- * We store 4 things for a given consumer (in this order) -
+ * We store 5 things for a given consumer (in this order) -
  *   1. Consumer name length (8 bytes)
  *   2. Head arena id (8 bytes)
  *   3. Head position in the arena (8 bytes)
- *   4. Name of the consumer (length)
+ *   4. Sequence number of the element at the head (8 bytes)
+ *   5. Name of the consumer (length)
+ *
+ * Producers are stored along with the consumers, the highest bit of
+ * the length of their ID is set. We store for a given producer -
+ *   1. Producer ID length (8 bytes)
+ *   2. Last accepted sequence number (8 bytes)
+ *   3. 1 if a sequence number has been accepted, otherwise 0 (8 bytes)
+ *   4. Unused (8 bytes)
+ *   5. ID of the producer (length)
  */
 
 // getConsumerLength reads the length of the consumer name for
@@ -196,7 +512,12 @@ func (m *metadata) putNumConsumers(size int) {
 //	| byte base - base+3 | byte base+4 - base+7 |
 //	+--------------------+----------------------+
 func (m *metadata) getConsumerLength(base int64) int {
-	return int(m.aa.ReadUint64At(base))
+	return int(m.aa.ReadUint64At(base) &^ cProducerFlag)
+}
+
+// isProducer returns true if the entry stored at a given base offset is a producer.
+func (m *metadata) isProducer(base int64) bool {
+	return m.aa.ReadUint64At(base)&cProducerFlag != 0
 }
 
 // putConsumerLength writes the length of the consumer name into the metadata file.
@@ -221,19 +542,35 @@ func (m *metadata) putConsumerHead(base int64, aid, pos int) {
 	m.aa.WriteUint64At(uint64(pos), base+16)
 }
 
+// getConsumerSeq reads the sequence number of the element at the head of
+// the consumer stored at a given base offset in metadata file.
+//
+//	 <------------------ consumer head sequence ----------------->
+//	+------------------------+------------------------+
+//	| byte base+24 - base+27 | byte base+28 - base+31 |
+//	+------------------------+------------------------+
+func (m *metadata) getConsumerSeq(base int64) uint64 {
+	return m.aa.ReadUint64At(base + 24)
+}
+
+// putConsumerSeq writes the sequence number of the head of the consumer into the metadata file.
+func (m *metadata) putConsumerSeq(base int64, seq uint64) {
+	m.aa.WriteUint64At(seq, base+24)
+}
+
 // getConsumerName reads the name of the consumer stored at a given offset in metadata.
 func (m *metadata) getConsumerName(base int64) string {
 	sb := &strings.Builder{}
 	length := m.getConsumerLength(base)
 	sb.Grow(length)
-	_ = m.aa.ReadStringAt(sb, base+24, int64(length))
+	_ = m.aa.ReadStringAt(sb, base+32, int64(length))
 	return sb.String()
 }
 
 // putConsumerName writes the name of the consumer in the metadata file.
-// name is stored at offset 24 until it can be fully stored in the file.
+// name is stored at offset 32 until it can be fully stored in the file.
 func (m *metadata) putConsumerName(base int64, name string) {
-	m.aa.WriteStringAt(name, base+24)
+	m.aa.WriteStringAt(name, base+32)
 }
 
 // putConsumer writes the consumer in the metadata file.
@@ -241,6 +578,7 @@ func (m *metadata) putConsumer(base int64, name string) {
 	m.putConsumerLength(base, len(name))
 	aid, offset := m.getHead()
 	m.putConsumerHead(base, aid, offset)
+	m.putConsumerSeq(base, m.getHeadSeq())
 	m.putConsumerName(base, name)
 	m.putNumConsumers(m.getNumConsumers() + 1)
 	m.co[name] = base
@@ -254,7 +592,7 @@ func (m *metadata) getConsumer(name string) (int64, error) {
 	}
 
 	oldsize := m.size
-	newsize := m.size + 24 + int64(len(name))
+	newsize := m.size + 32 + int64(len(name))
 	if err := m.extendFile(newsize); err != nil {
 		return 0, err
 	}
@@ -264,6 +602,44 @@ func (m *metadata) getConsumer(name string) (int64, error) {
 	return oldsize, nil
 }
 
+// getProducerSeq reads the last sequence number accepted from the producer stored
+// at a given base offset. It returns false if no sequence number has been accepted.
+//
+//	 <------------ last accepted seq -----------> <--------------- accepted ---------------->
+//	+-----------------------+------------------------+------------------------+------------------------+
+//	| byte base+8 - base+11 | byte base+12 - base+15 | byte base+16 - base+19 | byte base+20 - base+23 |
+//	+-----------------------+------------------------+------------------------+------------------------+
+func (m *metadata) getProducerSeq(base int64) (uint64, bool) {
+	return m.aa.ReadUint64At(base + 8), m.aa.ReadUint64At(base+16) != 0
+}
+
+// putProducerSeq writes the last accepted sequence number of the producer into the metadata file.
+func (m *metadata) putProducerSeq(base int64, seq uint64) {
+	m.aa.WriteUint64At(seq, base+8)
+	m.aa.WriteUint64At(1, base+16)
+}
+
+// getProducer either finds an existing producer with the given ID or initializes
+// a new producer with the given ID and stores it into the metadata file.
+func (m *metadata) getProducer(id string) (int64, error) {
+	if b, ok := m.pr[id]; ok {
+		return b, nil
+	}
+
+	base := m.size
+	newsize := m.size + 32 + int64(len(id))
+	if err := m.extendFile(newsize); err != nil {
+		return 0, err
+	}
+
+	m.size = newsize
+	m.aa.WriteUint64At(uint64(len(id))|cProducerFlag, base)
+	m.putConsumerName(base, id)
+	m.putNumConsumers(m.getNumConsumers() + 1)
+	m.pr[id] = base
+	return base, nil
+}
+
 // flush writes the memory state of the metadata arena on to disk.
 func (m *metadata) flush() error {
 	return m.aa.Flush(syscall.MS_SYNC)
@@ -278,6 +654,25 @@ func (m *metadata) close() error {
 	return m.aa.Unmap()
 }
 
+// insertAt inserts n zero bytes into the metadata file at given offset
+// and moves all the data stored after the offset towards the end.
+func (m *metadata) insertAt(offset, n int64) error {
+	oldsize := m.size
+	if err := m.extendFile(oldsize + n); err != nil {
+		return err
+	}
+	m.size = oldsize + n
+
+	if oldsize > offset {
+		data := make([]byte, oldsize-offset)
+		_, _ = m.aa.ReadAt(data, offset)
+		_, _ = m.aa.WriteAt(data, offset+n)
+	}
+
+	_, _ = m.aa.WriteAt(make([]byte, n), offset)
+	return nil
+}
+
 // extendFile extends the metadata file to given size.
 func (m *metadata) extendFile(size int64) error {
 	if err := m.close(); err != nil {
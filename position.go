@@ -0,0 +1,150 @@
+package bigqueue
+
+import (
+	"encoding/binary"
+	"errors"
+	"iter"
+)
+
+const (
+	cPositionSize = 16 + 3*cInt64Size
+)
+
+// ErrInvalidPosition is returned when a Position cannot be decoded,
+// or when it doesn't refer to the start of an element of the queue.
+var ErrInvalidPosition = errors.New("invalid position")
+
+// Position identifies the location of an element in a queue. Positions are
+// comparable and are only valid for the queue that they were obtained from.
+// The zero value of Position refers to the head of the queue.
+type Position struct {
+	id     [16]byte
+	aid    int
+	offset int
+	seq    uint64
+}
+
+// Seq returns the sequence number of the element at the position.
+func (p Position) Seq() uint64 {
+	return p.seq
+}
+
+// Compare returns -1, 0 or +1 depending on whether the element at position p is
+// before, same as or after the element at the other position. Positions are only
+// ordered within a queue, the result is meaningless for positions of different queues.
+func (p Position) Compare(other Position) int {
+	return comparePos(p.aid, p.offset, other.aid, other.offset)
+}
+
+// MarshalBinary encodes the position into a binary form
+// that can be decoded again using UnmarshalBinary.
+func (p Position) MarshalBinary() ([]byte, error) {
+	data := make([]byte, cPositionSize)
+	copy(data, p.id[:])
+	binary.LittleEndian.PutUint64(data[16:], uint64(p.aid))
+	binary.LittleEndian.PutUint64(data[16+cInt64Size:], uint64(p.offset))
+	binary.LittleEndian.PutUint64(data[16+2*cInt64Size:], p.seq)
+	return data, nil
+}
+
+// UnmarshalBinary decodes a position encoded using MarshalBinary.
+func (p *Position) UnmarshalBinary(data []byte) error {
+	if len(data) != cPositionSize {
+		return ErrInvalidPosition
+	}
+
+	copy(p.id[:], data)
+	p.aid = int(binary.LittleEndian.Uint64(data[16:]))
+	p.offset = int(binary.LittleEndian.Uint64(data[16+cInt64Size:]))
+	p.seq = binary.LittleEndian.Uint64(data[16+2*cInt64Size:])
+	return nil
+}
+
+// Scan returns an iterator over the elements of the queue along with their
+// positions, starting at the given position. Scan doesn't remove any element
+// from the queue and doesn't move the head of any consumer, hence, breaking out
+// of the loop early has no effect on the queue. Elements enqueued while scanning
+// are also returned. The iteration stops early if the position belongs to a
+// different queue or isn't the start of an element, see Seek, if the queue is
+// closed or if an element cannot be read.
+func (q *MmapQueue) Scan(from Position) iter.Seq2[Position, []byte] {
+	return func(yield func(Position, []byte) bool) {
+		if from != (Position{}) && !q.validPosition(from) {
+			return
+		}
+
+		pos := Position{id: q.id, aid: from.aid, offset: from.offset, seq: from.seq}
+		for {
+			msg, aid, offset, ok := q.scanNext(pos.aid, pos.offset)
+			if !ok || !yield(pos, msg) {
+				return
+			}
+
+			pos.aid, pos.offset, pos.seq = aid, offset, pos.seq+1
+		}
+	}
+}
+
+// scanNext reads the element at given position and returns it along with
+// the position of the next element. It returns false if no element is present.
+func (q *MmapQueue) scanNext(aid, offset int) ([]byte, int, int, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		return nil, 0, 0, false
+	}
+
+	tailAid, tailOffset := q.md.getTail()
+	if comparePos(aid, offset, tailAid, tailOffset) >= 0 {
+		return nil, 0, 0, false
+	}
+
+	// the length must fit before the tail, otherwise the queue is corrupted.
+	aid, offset, length, err := q.readLength(aid, offset)
+	if err != nil || length < 0 || q.distance(aid, offset, tailAid, tailOffset) < length {
+		return nil, 0, 0, false
+	}
+
+	var br bytesReader
+	br.grow(length)
+	aid, offset, err = q.readBytes(&br, aid, offset, length)
+	if err != nil {
+		return nil, 0, 0, false
+	}
+
+	return br.b, aid, offset, true
+}
+
+// validPosition returns true if the position belongs to the queue and
+// refers to the start of an element of the queue, or to its tail.
+func (q *MmapQueue) validPosition(pos Position) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return !q.isClosed() && pos.id == q.id && q.verifyPosition(pos) == nil
+}
+
+// advance moves the given position forward by n bytes across arenas.
+func (q *MmapQueue) advance(aid, offset, n int) (int, int) {
+	offset += n
+	return aid + offset/q.conf.arenaSize, offset % q.conf.arenaSize
+}
+
+// distance returns the number of bytes from the first position to the second.
+func (q *MmapQueue) distance(aid1, offset1, aid2, offset2 int) int {
+	return (aid2-aid1)*q.conf.arenaSize + offset2 - offset1
+}
+
+// comparePos compares two positions in the queue and returns -1, 0 or +1
+// depending on whether the first position is before, same or after the second.
+func comparePos(aid1, offset1, aid2, offset2 int) int {
+	switch {
+	case aid1 < aid2 || (aid1 == aid2 && offset1 < offset2):
+		return -1
+	case aid1 == aid2 && offset1 == offset2:
+		return 0
+	default:
+		return 1
+	}
+}
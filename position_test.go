@@ -0,0 +1,154 @@
+package bigqueue
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	for range bq.Scan(Position{}) {
+		t.Fatalf("Scan should not yield elements of an empty queue")
+	}
+
+	msgs := make([][]byte, 0, 10)
+	for i := range 10 {
+		msg := bytes.Repeat([]byte(strconv.Itoa(i)), 1000)
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	positions := make([]Position, 0, len(msgs))
+	for pos, msg := range bq.Scan(Position{}) {
+		if !bytes.Equal(msg, msgs[len(positions)]) {
+			t.Fatalf("messages don't match for element %d", len(positions))
+		}
+		positions = append(positions, pos)
+	}
+	if len(positions) != len(msgs) {
+		t.Fatalf("unexpected number of elements, exp: %d, actual: %d", len(msgs), len(positions))
+	}
+
+	// scanning doesn't move the head of the queue
+	if msg, err := bq.Peek(); err != nil {
+		t.Fatalf("Peek failed :: %v", err)
+	} else if !bytes.Equal(msg, msgs[0]) {
+		t.Fatalf("Scan should not move the head")
+	}
+
+	// scanning from a position in the middle, breaking early
+	i := 5
+	for pos, msg := range bq.Scan(positions[5]) {
+		if pos != positions[i] || !bytes.Equal(msg, msgs[i]) {
+			t.Fatalf("messages don't match for element %d", i)
+		}
+		i++
+		if i == 8 {
+			break
+		}
+	}
+	if i != 8 {
+		t.Fatalf("Scan should yield elements from the given position, read: %d", i)
+	}
+
+	// positions that don't refer to the start of an element are not accepted
+	data, err := positions[5].MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed :: %v", err)
+	}
+	var mid Position
+	if err := mid.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed :: %v", err)
+	}
+	mid.offset = 3
+	for range bq.Scan(mid) {
+		t.Fatalf("Scan should not accept a position in the middle of an element")
+	}
+
+	// positions from a different queue are not accepted
+	other, err := NewMmapQueue(t.TempDir(), SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := other.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+	if err := other.Enqueue(msgs[0]); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+	for range other.Scan(positions[0]) {
+		t.Fatalf("Scan should not accept a position of a different queue")
+	}
+}
+
+func TestEnqueueWithPosition(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	positions := make([]Position, 0, 10)
+	for i := range 10 {
+		pos, err := bq.EnqueueWithPosition(bytes.Repeat([]byte(strconv.Itoa(i)), 1000))
+		if err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+		if i > 0 && pos.Compare(positions[i-1]) <= 0 {
+			t.Fatalf("positions should be increasing, element %d", i)
+		}
+		positions = append(positions, pos)
+	}
+
+	if positions[0].Compare(positions[0]) != 0 || positions[1].Compare(positions[0]) != 1 {
+		t.Fatalf("unexpected result of Compare")
+	}
+
+	i := 0
+	for pos := range bq.Scan(Position{}) {
+		if pos != positions[i] {
+			t.Fatalf("positions from Scan don't match for element %d", i)
+		}
+		i++
+	}
+
+	data, err := positions[5].MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed :: %v", err)
+	}
+	var pos Position
+	if err := pos.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed :: %v", err)
+	} else if pos != positions[5] {
+		t.Fatalf("decoded position doesn't match")
+	}
+	if err := pos.UnmarshalBinary(data[1:]); err != ErrInvalidPosition {
+		t.Fatalf("expected invalid position error, returned: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package bigqueue
+
+// Producer is a bigqueue producer that enqueues elements idempotently. Every element
+// is enqueued along with a sequence number chosen by the producer, which must increase
+// with every element. The last sequence number accepted from the producer is persisted
+// in the metadata and elements with a sequence number that is not greater are dropped,
+// hence, a producer can safely enqueue elements again after a crash.
+// A producer is represented using just a base offset into the metadata.
+type Producer struct {
+	mq   *MmapQueue
+	id   string
+	base int64 // base offset in the metadata file
+}
+
+// NewProducer creates a new producer or finds an existing one with same ID.
+func (q *MmapQueue) NewProducer(id string) (*Producer, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	base, err := q.md.getProducer(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Producer{mq: q, id: id, base: base}, nil
+}
+
+// Enqueue adds a new element to the tail of the queue, unless a sequence number
+// greater than or equal to seq has already been accepted from the producer, in
+// which case the element is dropped silently.
+func (p *Producer) Enqueue(seq uint64, message []byte) error {
+	p.mq.wlock.Lock()
+	defer p.mq.wlock.Unlock()
+	p.mq.lock.Lock()
+	defer p.mq.lock.Unlock()
+
+	if last, ok := p.mq.md.getProducerSeq(p.base); ok && seq <= last {
+		return nil
+	}
+
+	p.mq.bw.b = message
+	err := p.mq.enqueue(&p.mq.bw)
+	p.mq.bw.b = nil
+	if err != nil {
+		return err
+	}
+
+	// sequence number is stored after the element is enqueued, if the process
+	// crashes in between, the element is enqueued twice instead of being lost.
+	p.mq.md.putProducerSeq(p.base, seq)
+	return nil
+}
+
+// LastSeq returns the last sequence number accepted from the producer.
+// It returns false if no element has been accepted from the producer yet.
+func (p *Producer) LastSeq() (uint64, bool) {
+	p.mq.lock.Lock()
+	defer p.mq.lock.Unlock()
+
+	return p.mq.md.getProducerSeq(p.base)
+}
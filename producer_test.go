@@ -0,0 +1,90 @@
+package bigqueue
+
+import (
+	"testing"
+)
+
+func TestProducer(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+
+	p, err := bq.NewProducer("producer")
+	if err != nil {
+		t.Fatalf("unable to create producer :: %v", err)
+	}
+	if _, ok := p.LastSeq(); ok {
+		t.Fatalf("new producer should not have a sequence number")
+	}
+
+	// a consumer with the same name is independent of the producer
+	c, err := bq.NewConsumer("producer")
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+
+	enqueue := func(p *Producer, seqs ...uint64) {
+		t.Helper()
+
+		for _, seq := range seqs {
+			if err := p.Enqueue(seq, []byte{byte(seq)}); err != nil {
+				t.Fatalf("enqueue failed :: %v", err)
+			}
+		}
+	}
+	dequeue := func(c *Consumer, want ...byte) {
+		t.Helper()
+
+		for _, b := range want {
+			if msg, err := c.Dequeue(); err != nil || len(msg) != 1 || msg[0] != b {
+				t.Fatalf("expected element %d, got: %v :: %v", b, msg, err)
+			}
+		}
+		if !c.IsEmpty() {
+			t.Fatalf("replayed elements should be dropped")
+		}
+	}
+
+	enqueue(p, 0, 1, 2, 1, 2, 4, 3)
+	dequeue(c, 0, 1, 2, 4)
+	if seq, ok := p.LastSeq(); !ok || seq != 4 {
+		t.Fatalf("last sequence should be 4, got: %d", seq)
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	// sequence numbers are persisted per producer
+	bq, err = NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if c, err = bq.NewConsumer("producer"); err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	if p, err = bq.NewProducer("producer"); err != nil {
+		t.Fatalf("unable to create producer :: %v", err)
+	}
+	other, err := bq.NewProducer("other")
+	if err != nil {
+		t.Fatalf("unable to create producer :: %v", err)
+	}
+
+	enqueue(p, 4, 5)
+	enqueue(other, 0, 0)
+	dequeue(c, 5, 0)
+	if len(bq.md.co) != 2 || len(bq.md.pr) != 2 {
+		t.Fatalf("expected 2 consumers and 2 producers, got: %d, %d", len(bq.md.co), len(bq.md.pr))
+	}
+}
@@ -1,14 +1,45 @@
 package bigqueue
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"iter"
+	"sync"
 )
 
 var (
 	// ErrEmptyQueue is returned when dequeue is performed on an empty queue.
 	ErrEmptyQueue = errors.New("queue is empty")
+	// ErrBufferTooSmall is returned when the element at the head of
+	// the queue doesn't fit in the buffer provided by the caller.
+	ErrBufferTooSmall = errors.New("buffer is too small for the element")
 )
 
+// cBufPool holds buffers used for elements that are spread across multiple arenas.
+var cBufPool = sync.Pool{
+	New: func() any {
+		return new([]byte)
+	},
+}
+
+// BufferTooSmallError is returned by DequeueInto when the element at the
+// head of the queue doesn't fit in the given buffer. It matches ErrBufferTooSmall
+// when used with errors.Is and carries the length of the element.
+type BufferTooSmallError struct {
+	Len int // length of the element at the head of the queue
+}
+
+// Error returns the error message along with the length of the element.
+func (e *BufferTooSmallError) Error() string {
+	return fmt.Sprintf("%v :: need %d bytes", ErrBufferTooSmall, e.Len)
+}
+
+// Is reports whether the target is ErrBufferTooSmall.
+func (e *BufferTooSmallError) Is(target error) bool {
+	return target == ErrBufferTooSmall
+}
+
 // IsEmpty returns true when queue is empty for the default consumer.
 func (q *MmapQueue) IsEmpty() bool {
 	return q.isEmpty(q.dc)
@@ -22,7 +53,7 @@ func (q *MmapQueue) isEmpty(base int64) bool {
 }
 
 func (q *MmapQueue) isEmptyNoLock(base int64) bool {
-	headAid, headOffset := q.md.getConsumerHead(base)
+	headAid, headOffset := q.getConsumerHead(base)
 	tailAid, tailOffset := q.md.getTail()
 	return headAid == tailAid && headOffset == tailOffset
 }
@@ -46,6 +77,223 @@ func (q *MmapQueue) dequeue(base int64) ([]byte, error) {
 	return r, nil
 }
 
+// DequeueWait removes an element from the queue and returns it. If the queue is
+// empty, it blocks until an element is enqueued. It returns ctx.Err() if the context
+// is done and ErrQueueClosed if the queue is closed while waiting for an element.
+// This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) DequeueWait(ctx context.Context) ([]byte, error) {
+	return q.dequeueWait(ctx, q.dc)
+}
+
+func (q *MmapQueue) dequeueWait(ctx context.Context, base int64) ([]byte, error) {
+	for {
+		q.lock.Lock()
+		if q.isClosed() {
+			q.lock.Unlock()
+			return nil, ErrQueueClosed
+		}
+
+		if err := q.dropExpired(base); err != nil {
+			q.lock.Unlock()
+			return nil, err
+		}
+
+		if !q.isEmptyNoLock(base) {
+			err := q.dequeueReader(&q.br, base)
+			r := q.br.b
+			q.br.b = nil
+			q.lock.Unlock()
+
+			if err != nil {
+				return nil, err
+			}
+			return r, nil
+		}
+
+		wait := q.enqueueNotifier()
+		q.lock.Unlock()
+
+		if err := q.waitFor(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// DequeueInto removes an element from the queue and copies it into buf,
+// reusing the capacity of buf. It returns buf resliced to the length of the
+// element. If the element is longer than cap(buf), a *BufferTooSmallError
+// is returned carrying the length of the element, and the element stays
+// in the queue. This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) DequeueInto(buf []byte) ([]byte, error) {
+	return q.dequeueInto(q.dc, buf)
+}
+
+func (q *MmapQueue) dequeueInto(base int64, buf []byte) ([]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	length, err := q.nextLength(base)
+	if err != nil {
+		return nil, err
+	}
+	if length > cap(buf) {
+		return nil, &BufferTooSmallError{Len: length}
+	}
+
+	q.br.b = buf[:0]
+	err = q.dequeueReader(&q.br, base)
+	r := q.br.b
+	q.br.b = nil
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// AppendDequeue removes an element from the queue, appends it to dst and
+// returns the extended slice. dst is grown only if it doesn't have enough
+// spare capacity to hold the element. In case of an error, dst is returned
+// unchanged. This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) AppendDequeue(dst []byte) ([]byte, error) {
+	return q.appendDequeue(q.dc, dst)
+}
+
+func (q *MmapQueue) appendDequeue(base int64, dst []byte) ([]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.br.b = dst
+	err := q.dequeueReader(&q.br, base)
+	r := q.br.b
+	q.br.b = nil
+	if err != nil {
+		return dst, err
+	}
+	return r, nil
+}
+
+// DequeueFunc calls fn with the element at the head of the queue and removes the
+// element only if fn returns nil. Otherwise, the error returned by fn is returned and
+// the element stays in the queue. If the element is stored in a single arena, msg
+// aliases the memory mapped arena and no copy is made. msg is valid only until fn
+// returns and must not be modified. fn is called while the queue is locked, hence,
+// it must not call any method of the queue. This function uses the default consumer
+// to consume from the queue.
+func (q *MmapQueue) DequeueFunc(fn func(msg []byte) error) error {
+	return q.dequeueFunc(q.dc, fn)
+}
+
+func (q *MmapQueue) dequeueFunc(base int64, fn func(msg []byte) error) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := q.dropExpired(base); err != nil {
+		return err
+	}
+	if q.isEmptyNoLock(base) {
+		return ErrEmptyQueue
+	}
+
+	aid, offset := q.getConsumerHead(base)
+	aid, offset, length, err := q.readLength(aid, offset)
+	if err != nil {
+		return err
+	}
+
+	var msg []byte
+	if offset+length <= q.conf.arenaSize {
+		// element is stored in one arena, we can use the mapped memory directly.
+		aa, err := q.am.getArena(aid)
+		if err != nil {
+			return err
+		}
+
+		msg = aa.view()[offset : offset+length : offset+length]
+		aid, offset = q.advance(aid, offset, length)
+	} else {
+		// element is spread across arenas, we copy it into a pooled buffer.
+		bp, _ := cBufPool.Get().(*[]byte)
+		br := bytesReader{b: (*bp)[:0]}
+		defer func() {
+			*bp = br.b[:0]
+			cBufPool.Put(bp)
+		}()
+
+		br.grow(length)
+		aid, offset, err = q.readBytes(&br, aid, offset, length)
+		if err != nil {
+			return err
+		}
+		msg = br.b
+	}
+
+	if err := fn(msg); err != nil {
+		return err
+	}
+
+	q.moveHead(base, aid, offset, 1)
+	return nil
+}
+
+// Messages returns an iterator that removes elements from the queue one at a time
+// and yields them until the queue is empty. Each element is removed from the queue
+// before it is yielded, hence, breaking out of the loop early leaves all the elements
+// that have not been yielded yet in the queue. The context is checked before removing
+// every element and ctx.Err() is yielded once the context is done. Any other error
+// is also yielded and ends the iteration. To keep waiting for new elements once the
+// queue is empty, use DequeueWait. This function uses the default consumer to
+// consume from the queue.
+func (q *MmapQueue) Messages(ctx context.Context) iter.Seq2[[]byte, error] {
+	return q.messages(ctx, q.dc)
+}
+
+func (q *MmapQueue) messages(ctx context.Context, base int64) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+
+			msg, err := q.dequeue(base)
+			if err == ErrEmptyQueue {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if !yield(msg, nil) {
+				return
+			}
+		}
+	}
+}
+
+// DequeueMsg removes an element from the queue and returns it along with its
+// sequence number. This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) DequeueMsg() (Message, error) {
+	return q.dequeueMsg(q.dc)
+}
+
+func (q *MmapQueue) dequeueMsg(base int64) (Message, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// sequence number is read after the expired elements are dropped.
+	if err := q.dropExpired(base); err != nil {
+		return Message{}, err
+	}
+	seq := q.getConsumerSeq(base)
+	if err := q.dequeueReader(&q.br, base); err != nil {
+		q.br.b = nil
+		return Message{}, err
+	}
+	msg := Message{Seq: seq, Data: q.br.b}
+	q.br.b = nil
+	return msg, nil
+}
+
 // DequeueString removes a string element from the queue and returns it.
 // This function uses the default consumer to consume from the queue.
 func (q *MmapQueue) DequeueString() (string, error) {
@@ -65,60 +313,256 @@ func (q *MmapQueue) dequeueString(base int64) (string, error) {
 	return r, nil
 }
 
-// dequeue reads one element of the queue into given reader.
-// It takes care of reading the element that is spread across multiple arenas.
-func (q *MmapQueue) dequeueReader(r reader, base int64) error {
+// Peek returns the element at the head of the queue without removing it.
+// This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) Peek() ([]byte, error) {
+	return q.peek(q.dc)
+}
+
+func (q *MmapQueue) peek(base int64) ([]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := q.peekReader(&q.br, base); err != nil {
+		q.br.b = nil
+		return nil, err
+	}
+	r := q.br.b
+	q.br.b = nil
+	return r, nil
+}
+
+// PeekString returns the string element at the head of the queue without removing it.
+// This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) PeekString() (string, error) {
+	return q.peekString(q.dc)
+}
+
+func (q *MmapQueue) peekString(base int64) (string, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := q.peekReader(&q.sr, base); err != nil {
+		q.sr.sb.Reset()
+		return "", err
+	}
+	r := q.sr.sb.String()
+	q.sr.sb.Reset()
+	return r, nil
+}
+
+// PeekN returns up to n elements from the head of the queue without removing them.
+// Fewer than n elements are returned if the queue doesn't have enough elements.
+// This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) PeekN(n int) ([][]byte, error) {
+	return q.peekN(q.dc, n)
+}
+
+func (q *MmapQueue) peekN(base int64, n int) ([][]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := q.dropExpired(base); err != nil {
+		return nil, err
+	}
 	if q.isEmptyNoLock(base) {
-		return ErrEmptyQueue
+		return nil, ErrEmptyQueue
 	}
 
-	// read head
-	aid, offset := q.md.getConsumerHead(base)
+	msgs, _, _, _, err := q.readBatch(base, n, 0, false)
+	return msgs, err
+}
+
+// NextLen returns the length of the element at the head of the queue without
+// removing it or reading its content. This function uses the default consumer.
+func (q *MmapQueue) NextLen() (int, error) {
+	return q.nextLen(q.dc)
+}
+
+func (q *MmapQueue) nextLen(base int64) (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.nextLength(base)
+}
+
+// BacklogBytes returns the number of bytes in the queue from the head to the tail.
+// It includes the length prefix of each element as well as the bytes left unused at
+// the end of an arena when a length prefix doesn't fit, hence, it is an upper bound
+// of the total length of the elements. This function uses the default consumer.
+func (q *MmapQueue) BacklogBytes() int {
+	return q.backlogBytes(q.dc)
+}
+
+func (q *MmapQueue) backlogBytes(base int64) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	headAid, headOffset := q.getConsumerHead(base)
+	tailAid, tailOffset := q.md.getTail()
+	return q.distance(headAid, headOffset, tailAid, tailOffset)
+}
+
+// DequeueBatch removes up to maxMsgs elements from the queue, as long as their
+// total length doesn't exceed maxBytes, and returns them. The elements are read
+// while holding the lock once and the head of the queue is updated only once.
+// At least one element is returned if the queue is not empty, even if its length
+// exceeds maxBytes. If maxBytes <= 0, total length of elements is not limited.
+// This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) DequeueBatch(maxMsgs, maxBytes int) ([][]byte, error) {
+	return q.dequeueBatch(q.dc, maxMsgs, maxBytes)
+}
 
-	// read length
-	newAid, newOffset, length, err := q.readLength(aid, offset)
+func (q *MmapQueue) dequeueBatch(base int64, maxMsgs, maxBytes int) ([][]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := q.dropExpired(base); err != nil {
+		return nil, err
+	}
+	if q.isEmptyNoLock(base) {
+		return nil, ErrEmptyQueue
+	}
+
+	msgs, aid, offset, n, err := q.readBatch(base, max(maxMsgs, 1), maxBytes, true)
 	if err != nil {
+		return nil, err
+	}
+
+	q.moveHead(base, aid, offset, n)
+	return msgs, nil
+}
+
+// dequeueReader reads one element of the queue into given reader and removes it.
+// It takes care of reading the element that is spread across multiple arenas.
+func (q *MmapQueue) dequeueReader(r reader, base int64) error {
+	if err := q.dropExpired(base); err != nil {
 		return err
 	}
-	aid, offset = newAid, newOffset
+	if q.isEmptyNoLock(base) {
+		return ErrEmptyQueue
+	}
+
+	// read head
+	aid, offset := q.getConsumerHead(base)
 
 	// read message
-	r.grow(length)
-	aid, offset, err = q.readBytes(r, aid, offset, length)
+	aid, offset, err := q.readRecord(r, aid, offset)
 	if err != nil {
 		return err
 	}
 
 	// update head
-	q.md.putConsumerHead(base, aid, offset)
+	q.moveHead(base, aid, offset, 1)
+	return nil
+}
+
+// moveHead moves the head of the consumer past n elements and
+// drops the expired records skipped by the consumer on the way.
+func (q *MmapQueue) moveHead(base int64, aid, offset, n int) {
+	q.putConsumerHead(base, aid, offset)
+	q.putConsumerSeq(base, q.getConsumerSeq(base)+uint64(n))
+	q.dropSkipped(base)
 	q.incrMutOps()
+}
 
-	return nil
+// peekReader reads one element of the queue into given reader without removing it.
+func (q *MmapQueue) peekReader(r reader, base int64) error {
+	if err := q.dropExpired(base); err != nil {
+		return err
+	}
+	if q.isEmptyNoLock(base) {
+		return ErrEmptyQueue
+	}
+
+	aid, offset := q.getConsumerHead(base)
+	_, _, err := q.readRecord(r, aid, offset)
+	return err
 }
 
-// readLength reads length of the message.
-// length is always written in 1 arena, it is never broken across arenas.
-func (q *MmapQueue) readLength(aid, offset int) (int, int, int, error) {
-	// check if length is present in same arena, if not get next arena.
-	// If length is stored in next arena, get next aid with 0 offset value.
-	if offset+cInt64Size > q.conf.arenaSize {
-		aid, offset = aid+1, 0
+// nextLength returns the length of the element at the head of the queue.
+func (q *MmapQueue) nextLength(base int64) (int, error) {
+	if err := q.dropExpired(base); err != nil {
+		return 0, err
+	}
+	if q.isEmptyNoLock(base) {
+		return 0, ErrEmptyQueue
 	}
 
-	// read length
-	aa, err := q.am.getArena(aid)
+	aid, offset := q.getConsumerHead(base)
+	_, _, length, err := q.readLength(aid, offset)
+	return length, err
+}
+
+// readBatch reads up to maxMsgs records starting at the head of the consumer, as long
+// as total length of the records doesn't exceed maxBytes (if > 0), and returns the records
+// along with the position of the next record and the number of records passed, including
+// the expired ones that are skipped. Skipped records are marked only if drop is true, see
+// skipExpired. The first record is read irrespective of maxBytes.
+func (q *MmapQueue) readBatch(base int64, maxMsgs, maxBytes int, drop bool) ([][]byte, int, int, int, error) {
+	aid, offset := q.getConsumerHead(base)
+	seq := q.getConsumerSeq(base)
+	tailAid, tailOffset := q.md.getTail()
+	msgs := make([][]byte, 0, max(min(maxMsgs, 64), 0))
+	total, n := 0, 0
+	for len(msgs) < maxMsgs {
+		var skipped int
+		var err error
+		aid, offset, skipped, err = q.skipExpired(base, aid, offset, seq+uint64(n), drop)
+		if err != nil {
+			return nil, 0, 0, 0, err
+		}
+		n += skipped
+		if aid == tailAid && offset == tailOffset {
+			break
+		}
+
+		newAid, newOffset, length, err := q.readLength(aid, offset)
+		if err != nil {
+			return nil, 0, 0, 0, err
+		}
+
+		if len(msgs) > 0 && maxBytes > 0 && total+length > maxBytes {
+			break
+		}
+
+		var br bytesReader
+		br.grow(length)
+		aid, offset, err = q.readBytes(&br, newAid, newOffset, length)
+		if err != nil {
+			return nil, 0, 0, 0, err
+		}
+
+		msgs = append(msgs, br.b)
+		total += length
+		n++
+	}
+
+	return msgs, aid, offset, n, nil
+}
+
+// readRecord reads the record (length and message) stored at given
+// position into given reader and returns the position of the next record.
+func (q *MmapQueue) readRecord(r reader, aid, offset int) (int, int, error) {
+	aid, offset, length, err := q.readLength(aid, offset)
 	if err != nil {
-		return 0, 0, 0, err
+		return 0, 0, err
 	}
-	length := int(aa.ReadUint64At(int64(offset)))
 
-	// update offset, if offset is equal to arena size,
-	// reset arena to next aid and offset to 0
-	offset += cInt64Size
-	if offset == q.conf.arenaSize {
-		aid, offset = aid+1, 0
+	r.grow(length)
+	return q.readBytes(r, aid, offset, length)
+}
+
+// readLength reads length of the message and returns the position of the message.
+// length is always written in 1 arena, it is never broken across arenas. If the
+// record has an envelope, the envelope is skipped and only the data is considered.
+func (q *MmapQueue) readLength(aid, offset int) (int, int, int, error) {
+	aid, offset, length, envLen, err := q.readFraming(aid, offset)
+	if err != nil {
+		return 0, 0, 0, err
 	}
 
+	aid, offset = q.advance(aid, offset, envLen)
 	return aid, offset, length, nil
 }
 
@@ -2,8 +2,6 @@ package bigqueue
 
 import (
 	"strings"
-
-	"github.com/grandecola/mmap"
 )
 
 // reader knows how to read data from arena.
@@ -16,20 +14,28 @@ type reader interface {
 	// readFrom copies data from arena starting at given offset. Because the data
 	// may be spread over multiple arenas, an index into the data is provided so
 	// the data is copied starting at given index.
-	readFrom(aa *mmap.File, offset, index int) int
+	readFrom(aa *arena, offset, index int) int
 }
 
-// bytesReader holds a slice of bytes to hold the data.
+// bytesReader holds a slice of bytes to hold the data. Data is appended
+// to the slice, reusing its spare capacity when there is enough of it.
 type bytesReader struct {
-	b []byte
+	b     []byte
+	start int // index in b where the data being read starts
 }
 
-// grow expands the capacity of bytesReader by n bytes.
+// grow expands the length of bytesReader by n bytes.
 func (br *bytesReader) grow(n int) {
 	if n < 0 {
 		panic("bigqueue.reader.grow: negative count")
 	}
 
+	br.start = len(br.b)
+	if br.b != nil && cap(br.b)-len(br.b) >= n {
+		br.b = br.b[:len(br.b)+n]
+		return
+	}
+
 	temp := make([]byte, n+len(br.b))
 	if br.b != nil {
 		_ = copy(temp, br.b)
@@ -38,8 +44,8 @@ func (br *bytesReader) grow(n int) {
 }
 
 // readFrom reads the arena at offset and copies the data at index.
-func (br *bytesReader) readFrom(aa *mmap.File, offset, index int) int {
-	n, _ := aa.ReadAt(br.b[index:], int64(offset))
+func (br *bytesReader) readFrom(aa *arena, offset, index int) int {
+	n, _ := aa.ReadAt(br.b[br.start+index:], int64(offset))
 	return n
 }
 
@@ -56,6 +62,6 @@ func (sr *stringReader) grow(n int) {
 }
 
 // readFrom reads data from arena starting at offset and stores it at provided index.
-func (sr *stringReader) readFrom(aa *mmap.File, offset, _ int) int {
+func (sr *stringReader) readFrom(aa *arena, offset, _ int) int {
 	return aa.ReadStringAt(&sr.sb, int64(offset), int64(sr.ecap-sr.sb.Len()))
 }
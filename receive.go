@@ -0,0 +1,369 @@
+package bigqueue
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	cInflightFileName  = "inflight.dat"
+	cInflightEntrySize = 9 * cInt64Size
+
+	// states of a slot in the in-flight file.
+	cSlotFree     = 0
+	cSlotInflight = 1
+	cSlotAcked    = 2
+)
+
+// Delivery is an element of the queue received using Receive. The element stays
+// in the queue until it is acknowledged, together with all the elements received
+// before it. An element that is neither acknowledged nor rejected is delivered
+// again once the visibility timeout has elapsed, see SetVisibilityTimeout.
+type Delivery struct {
+	Seq     uint64
+	Data    []byte
+	Attempt uint64 // 1 for the first delivery, incremented for every redelivery
+
+	q    *MmapQueue
+	base int64
+}
+
+// inflightEntry is an element received by a consumer that has not been removed yet.
+type inflightEntry struct {
+	slot      int
+	base      int64 // base offset of the consumer in the metadata file
+	seq       uint64
+	aid       int // position of the element
+	offset    int
+	endAid    int // position right after the element
+	endOffset int
+	deadline  int64 // in nanoseconds, the element is delivered again afterwards
+	attempts  uint64
+	acked     bool
+}
+
+// inflight keeps track of the elements that are received but not removed yet.
+// Every entry is stored in a fixed size slot of the in-flight file, slots of
+// removed entries are reused. An entry is removed once the head of its consumer
+// moves past it, which happens when all the entries before it are acknowledged.
+type inflight struct {
+	fd      *os.File
+	entries map[int64][]*inflightEntry // entries of every consumer ordered by seq
+	free    []int
+	slots   int
+}
+
+// newInflight opens or creates the in-flight file in given directory and loads it.
+// Entries after the tail of the queue are of elements that were never published,
+// e.g. because of a crash before the metadata was flushed, and are removed.
+func newInflight(dir string, tailAid, tailOffset int) (*inflight, error) {
+	fd, err := os.OpenFile(filepath.Join(dir, cInflightFileName), os.O_CREATE|os.O_RDWR, cFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("error in creating/opening in-flight file :: %w", err)
+	}
+
+	data, err := io.ReadAll(fd)
+	if err != nil {
+		_ = fd.Close()
+		return nil, fmt.Errorf("error in reading in-flight file :: %w", err)
+	}
+
+	inf := &inflight{fd: fd, entries: make(map[int64][]*inflightEntry)}
+	for ; len(data) >= cInflightEntrySize; data = data[cInflightEntrySize:] {
+		slot := inf.slots
+		inf.slots++
+
+		field := func(i int) uint64 { return binary.LittleEndian.Uint64(data[i*cInt64Size:]) }
+		if field(8) == cSlotFree {
+			inf.free = append(inf.free, slot)
+			continue
+		}
+
+		e := &inflightEntry{
+			slot:      slot,
+			base:      int64(field(0)),
+			seq:       field(1),
+			aid:       int(field(2)),
+			offset:    int(field(3)),
+			endAid:    int(field(4)),
+			endOffset: int(field(5)),
+			deadline:  int64(field(6)),
+			attempts:  field(7),
+			acked:     field(8) == cSlotAcked,
+		}
+		if comparePos(e.endAid, e.endOffset, tailAid, tailOffset) > 0 {
+			if _, err := fd.WriteAt(make([]byte, cInt64Size), int64(slot*cInflightEntrySize+8*cInt64Size)); err != nil {
+				_ = fd.Close()
+				return nil, fmt.Errorf("error in writing in-flight file :: %w", err)
+			}
+			inf.free = append(inf.free, slot)
+			continue
+		}
+		inf.entries[e.base] = append(inf.entries[e.base], e)
+	}
+
+	for _, entries := range inf.entries {
+		slices.SortFunc(entries, func(a, b *inflightEntry) int { return cmp.Compare(a.seq, b.seq) })
+	}
+
+	return inf, nil
+}
+
+// add stores a new entry in a free slot.
+func (inf *inflight) add(e *inflightEntry) error {
+	if n := len(inf.free); n > 0 {
+		e.slot = inf.free[n-1]
+		inf.free = inf.free[:n-1]
+	} else {
+		e.slot = inf.slots
+		inf.slots++
+	}
+
+	inf.entries[e.base] = append(inf.entries[e.base], e)
+	return inf.write(e)
+}
+
+// write writes the entry into its slot.
+func (inf *inflight) write(e *inflightEntry) error {
+	state := uint64(cSlotInflight)
+	if e.acked {
+		state = cSlotAcked
+	}
+
+	data := make([]byte, 0, cInflightEntrySize)
+	for _, v := range []uint64{uint64(e.base), e.seq, uint64(e.aid), uint64(e.offset),
+		uint64(e.endAid), uint64(e.endOffset), uint64(e.deadline), e.attempts, state} {
+		data = binary.LittleEndian.AppendUint64(data, v)
+	}
+
+	if _, err := inf.fd.WriteAt(data, int64(e.slot*cInflightEntrySize)); err != nil {
+		return fmt.Errorf("error in writing in-flight file :: %w", err)
+	}
+	return nil
+}
+
+// removeFirst removes the first n entries of the consumer and frees their slots.
+func (inf *inflight) removeFirst(base int64, n int) error {
+	entries := inf.entries[base]
+	for _, e := range entries[:n] {
+		var state [cInt64Size]byte
+		if _, err := inf.fd.WriteAt(state[:], int64(e.slot*cInflightEntrySize+8*cInt64Size)); err != nil {
+			return fmt.Errorf("error in writing in-flight file :: %w", err)
+		}
+		inf.free = append(inf.free, e.slot)
+	}
+
+	inf.entries[base] = entries[n:]
+	return nil
+}
+
+// find returns the entry of the consumer with given sequence number.
+func (inf *inflight) find(base int64, seq uint64) (*inflightEntry, bool) {
+	entries := inf.entries[base]
+	i, ok := slices.BinarySearchFunc(entries, seq, func(e *inflightEntry, seq uint64) int {
+		return cmp.Compare(e.seq, seq)
+	})
+	if !ok {
+		return nil, false
+	}
+	return entries[i], true
+}
+
+// flush writes the in-flight file on to disk.
+func (inf *inflight) flush() error {
+	if err := inf.fd.Sync(); err != nil {
+		return fmt.Errorf("error in syncing in-flight file :: %w", err)
+	}
+	return nil
+}
+
+// close closes the in-flight file.
+func (inf *inflight) close() error {
+	if err := inf.fd.Close(); err != nil {
+		return fmt.Errorf("error in closing in-flight file :: %w", err)
+	}
+	return nil
+}
+
+// Receive returns the next element of the queue without removing it. The element
+// is removed once it is acknowledged using Delivery.Ack. Until then, it is invisible
+// to further receives for the visibility timeout, after which it is delivered again.
+// Elements whose visibility timeout has elapsed are delivered before new elements.
+// The received elements are persisted in the in-flight file inside the queue directory,
+// hence, unacknowledged elements are not lost when the queue is reopened.
+// Receive must not be mixed with other functions that move the head of the consumer.
+// This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) Receive() (*Delivery, error) {
+	return q.receive(q.dc)
+}
+
+func (q *MmapQueue) receive(base int64) (*Delivery, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		return nil, ErrQueueClosed
+	}
+
+	if q.inf == nil {
+		tailAid, tailOffset := q.md.getTail()
+		inf, err := newInflight(q.dir, tailAid, tailOffset)
+		if err != nil {
+			return nil, err
+		}
+		q.inf = inf
+	}
+	if err := q.pruneInflight(base); err != nil {
+		return nil, err
+	}
+
+	// redeliver the oldest element whose visibility timeout has elapsed.
+	now := time.Now()
+	entries := q.inf.entries[base]
+	for _, e := range entries {
+		if e.acked || e.deadline > now.UnixNano() {
+			continue
+		}
+
+		var br bytesReader
+		if _, _, err := q.readRecord(&br, e.aid, e.offset); err != nil {
+			return nil, err
+		}
+
+		e.deadline = now.Add(q.conf.visibility).UnixNano()
+		e.attempts++
+		if err := q.inf.write(e); err != nil {
+			return nil, err
+		}
+		return &Delivery{Seq: e.seq, Data: br.b, Attempt: e.attempts, q: q, base: base}, nil
+	}
+
+	// new elements are received after the last received element.
+	if len(entries) == 0 {
+		if err := q.dropExpired(base); err != nil {
+			return nil, err
+		}
+	}
+	aid, offset := q.getConsumerHead(base)
+	seq := q.getConsumerSeq(base)
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		aid, offset, seq = last.endAid, last.endOffset, last.seq+1
+	}
+
+	// expired elements after received elements are skipped, they are dropped once the
+	// head moves past them, i.e. an element after them is acknowledged.
+	newAid, newOffset, skipped, err := q.skipExpired(base, aid, offset, seq, true)
+	if err != nil {
+		return nil, err
+	}
+	tailAid, tailOffset := q.md.getTail()
+	if newAid == tailAid && newOffset == tailOffset {
+		return nil, ErrEmptyQueue
+	}
+
+	var br bytesReader
+	endAid, endOffset, err := q.readRecord(&br, newAid, newOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &inflightEntry{
+		base:      base,
+		seq:       seq + uint64(skipped),
+		aid:       newAid,
+		offset:    newOffset,
+		endAid:    endAid,
+		endOffset: endOffset,
+		deadline:  now.Add(q.conf.visibility).UnixNano(),
+		attempts:  1,
+	}
+	if err := q.inf.add(e); err != nil {
+		return nil, err
+	}
+
+	q.incrMutOps()
+	return &Delivery{Seq: e.seq, Data: br.b, Attempt: e.attempts, q: q, base: base}, nil
+}
+
+// pruneInflight removes the entries of the consumer that are behind its
+// head, for example, because the head was moved by another function.
+func (q *MmapQueue) pruneInflight(base int64) error {
+	headSeq := q.getConsumerSeq(base)
+	entries := q.inf.entries[base]
+	n := 0
+	for n < len(entries) && entries[n].seq < headSeq {
+		n++
+	}
+
+	if n == 0 {
+		return nil
+	}
+	return q.inf.removeFirst(base, n)
+}
+
+// Ack acknowledges the element. The head of the consumer moves past the element
+// once all the elements received before it are acknowledged as well. Acknowledging
+// an element that was delivered again meanwhile acknowledges it for all deliveries.
+func (d *Delivery) Ack() error {
+	d.q.lock.Lock()
+	defer d.q.lock.Unlock()
+
+	if d.q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	if err := d.q.pruneInflight(d.base); err != nil {
+		return err
+	}
+
+	e, ok := d.q.inf.find(d.base, d.Seq)
+	if !ok || e.acked {
+		return nil
+	}
+
+	e.acked = true
+	if err := d.q.inf.write(e); err != nil {
+		return err
+	}
+
+	// move the head past all the acknowledged elements at the head.
+	entries := d.q.inf.entries[d.base]
+	n := 0
+	for n < len(entries) && entries[n].acked {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	last := entries[n-1]
+	headSeq := d.q.getConsumerSeq(d.base)
+	d.q.moveHead(d.base, last.endAid, last.endOffset, int(last.seq+1-headSeq))
+	return d.q.inf.removeFirst(d.base, n)
+}
+
+// Nack rejects the element, which makes it visible again right away. Rejecting
+// an element that was delivered again meanwhile, or was acknowledged, has no effect.
+func (d *Delivery) Nack() error {
+	d.q.lock.Lock()
+	defer d.q.lock.Unlock()
+
+	if d.q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	e, ok := d.q.inf.find(d.base, d.Seq)
+	if !ok || e.acked || e.attempts != d.Attempt {
+		return nil
+	}
+
+	e.deadline = 0
+	return d.q.inf.write(e)
+}
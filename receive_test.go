@@ -0,0 +1,148 @@
+package bigqueue
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, c *Consumer, seq, attempt uint64) *Delivery {
+	t.Helper()
+
+	d, err := c.Receive()
+	if err != nil {
+		t.Fatalf("Receive failed :: %v", err)
+	}
+	if d.Seq != seq || d.Attempt != attempt || string(d.Data) != strconv.Itoa(int(seq)) {
+		t.Fatalf("expected element %d, attempt %d, got: %s, seq: %d, attempt: %d",
+			seq, attempt, d.Data, d.Seq, d.Attempt)
+	}
+	return d
+}
+
+func ack(t *testing.T, ds ...*Delivery) {
+	t.Helper()
+
+	for _, d := range ds {
+		if err := d.Ack(); err != nil {
+			t.Fatalf("Ack failed :: %v", err)
+		}
+	}
+}
+
+func TestReceive(t *testing.T) {
+	t.Parallel()
+
+	visibility := 200 * time.Millisecond
+	bq, err := NewMmapQueue(t.TempDir(), SetArenaSize(8*1024), SetVisibilityTimeout(visibility))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	for i := range 5 {
+		if err := bq.EnqueueString(strconv.Itoa(i)); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	d0, d1, d2 := receive(t, c, 0, 1), receive(t, c, 1, 1), receive(t, c, 2, 1)
+
+	// head only moves once all the elements before are acknowledged
+	ack(t, d1)
+	if seq := bq.md.getConsumerSeq(c.base); seq != 0 {
+		t.Fatalf("head should not move before element 0 is acknowledged, seq: %d", seq)
+	}
+
+	// rejected elements are delivered again right away, stale rejections are ignored
+	if err := d0.Nack(); err != nil {
+		t.Fatalf("Nack failed :: %v", err)
+	}
+	d0 = receive(t, c, 0, 2)
+	if err := (&Delivery{Seq: 0, Attempt: 1, q: bq, base: c.base}).Nack(); err != nil {
+		t.Fatalf("Nack failed :: %v", err)
+	}
+	d3 := receive(t, c, 3, 1)
+	ack(t, d0)
+	if seq := bq.md.getConsumerSeq(c.base); seq != 2 {
+		t.Fatalf("head should move past acknowledged elements, seq: %d", seq)
+	}
+
+	// unacknowledged elements are delivered again after the visibility timeout
+	time.Sleep(visibility + 50*time.Millisecond)
+	d2, d3 = receive(t, c, 2, 2), receive(t, c, 3, 2)
+	d4 := receive(t, c, 4, 1)
+	if _, err := c.Receive(); err != ErrEmptyQueue {
+		t.Fatalf("Receive should return empty queue error, returned: %v", err)
+	}
+
+	ack(t, d4, d3, d2, d2)
+	if !c.IsEmpty() || len(bq.inf.entries[c.base]) != 0 || len(bq.inf.free) != bq.inf.slots {
+		t.Fatalf("all the elements should be removed, free slots: %d/%d", len(bq.inf.free), bq.inf.slots)
+	}
+
+	// other consumers are not affected
+	if d, err := bq.Receive(); err != nil || d.Seq != 0 {
+		t.Fatalf("default consumer should receive the first element :: %v", err)
+	}
+}
+
+func TestReceiveReopen(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	visibility := 100 * time.Millisecond
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024), SetVisibilityTimeout(visibility))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	for i := range 3 {
+		if err := bq.EnqueueString(strconv.Itoa(i)); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	receive(t, c, 0, 1)
+	ack(t, receive(t, c, 1, 1))
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	// received elements are persisted along with their visibility timeout
+	bq, err = NewMmapQueue(testDir, SetArenaSize(8*1024), SetVisibilityTimeout(visibility))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+	if c, err = bq.NewConsumer("consumer"); err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+
+	time.Sleep(visibility)
+	d0 := receive(t, c, 0, 2)
+	d2 := receive(t, c, 2, 1)
+	ack(t, d0, d2)
+	if !c.IsEmpty() || bq.md.getConsumerSeq(c.base) != 3 {
+		t.Fatalf("all the elements should be removed, seq: %d", bq.md.getConsumerSeq(c.base))
+	}
+
+	if _, err := NewMmapQueue(t.TempDir(), SetVisibilityTimeout(0)); err != ErrInvalidVisibilityTimeout {
+		t.Fatalf("expected error: %v, got: %v", ErrInvalidVisibilityTimeout, err)
+	}
+}
@@ -0,0 +1,268 @@
+package bigqueue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+const (
+	// cEnvelopeFlag is set in the length of a record that stores an envelope,
+	// i.e. the length of the envelope and the envelope itself, before the data.
+	cEnvelopeFlag = 1 << 63
+
+	// tags of the fields of an envelope.
+	cTagTimestamp = 1
+	cTagKey       = 2
+	cTagHeader    = 3
+	cTagExpiry    = 4
+	cTagReadyAt   = 5
+)
+
+// Record is an element of the queue along with its key, timestamp and headers.
+// These are stored next to the data of the element in an envelope. A record
+// with a non-zero ExpiresAt is dropped once it expires, see EnqueueWithTTL.
+// ReadyAt is set for records that were delayed using EnqueueAt or EnqueueAfter,
+// it is only informational and doesn't delay records added using EnqueueRecord.
+type Record struct {
+	Key       []byte
+	Timestamp time.Time
+	ExpiresAt time.Time
+	ReadyAt   time.Time
+	Headers   map[string]string
+	Data      []byte
+}
+
+// EnqueueRecord adds a new record to the tail of the queue. If the timestamp
+// of the record is not set, the current time is used instead. Dequeuing the
+// record using any of the other dequeue functions returns just its data.
+func (q *MmapQueue) EnqueueRecord(r Record) error {
+	q.wlock.Lock()
+	defer q.wlock.Unlock()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	_, err := q.enqueueRecord(r)
+	return err
+}
+
+// enqueueRecord writes the record to the tail of the queue and returns its position.
+func (q *MmapQueue) enqueueRecord(r Record) (Position, error) {
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now()
+	}
+
+	env := r.appendEnvelope(nil)
+	var envLen [cInt64Size]byte
+	binary.LittleEndian.PutUint64(envLen[:], uint64(len(env)))
+
+	q.vw.parts, q.vw.arenaSize = [][]byte{envLen[:], env, r.Data}, q.conf.arenaSize
+	defer func() { q.vw.parts = nil }()
+
+	tailAid, tailOffset := q.md.getTail()
+	pos := Position{id: q.id, aid: tailAid, offset: tailOffset, seq: q.md.getNextSeq()}
+	aid, offset, err := q.writeLength(tailAid, tailOffset, uint64(q.vw.len())|cEnvelopeFlag)
+	if err != nil {
+		return Position{}, err
+	}
+
+	aid, offset, err = q.writeBytes(&q.vw, aid, offset)
+	if err != nil {
+		return Position{}, err
+	}
+
+	q.publish(aid, offset, 1)
+	return pos, nil
+}
+
+// DequeueRecord removes a record from the queue and returns it. Elements that
+// were not added using EnqueueRecord are returned as a record with just the data.
+// This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) DequeueRecord() (Record, error) {
+	return q.dequeueRecord(q.dc)
+}
+
+func (q *MmapQueue) dequeueRecord(base int64) (Record, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := q.dropExpired(base); err != nil {
+		return Record{}, err
+	}
+	if q.isEmptyNoLock(base) {
+		return Record{}, ErrEmptyQueue
+	}
+
+	aid, offset := q.getConsumerHead(base)
+	r, aid, offset, err := q.readFullRecord(aid, offset)
+	if err != nil {
+		return Record{}, err
+	}
+
+	q.moveHead(base, aid, offset, 1)
+	return r, nil
+}
+
+// readFullRecord reads the record at given position including its
+// data and returns it along with the position of the next record.
+func (q *MmapQueue) readFullRecord(aid, offset int) (Record, int, int, error) {
+	r, aid, offset, length, err := q.readEnvelope(aid, offset)
+	if err != nil {
+		return Record{}, 0, 0, err
+	}
+
+	var br bytesReader
+	br.grow(length)
+	aid, offset, err = q.readBytes(&br, aid, offset, length)
+	if err != nil {
+		return Record{}, 0, 0, err
+	}
+
+	r.Data = br.b
+	return r, aid, offset, nil
+}
+
+// readEnvelope reads the record at given position without its data. It returns
+// the record along with the position and the length of the data.
+func (q *MmapQueue) readEnvelope(aid, offset int) (Record, int, int, int, error) {
+	aid, offset, length, envLen, err := q.readFraming(aid, offset)
+	if err != nil {
+		return Record{}, 0, 0, 0, err
+	}
+
+	var r Record
+	if envLen == 0 {
+		return r, aid, offset, length, nil
+	}
+
+	var br bytesReader
+	br.grow(envLen)
+	aid, offset, err = q.readBytes(&br, aid, offset, envLen)
+	if err != nil {
+		return Record{}, 0, 0, 0, err
+	}
+
+	if err := r.decodeEnvelope(br.b); err != nil {
+		return Record{}, 0, 0, 0, err
+	}
+
+	return r, aid, offset, length, nil
+}
+
+// readFraming reads the length of the record at given position and the length of
+// its envelope, if the record has one. It returns the position of the envelope,
+// followed by the length of the data and the length of the envelope.
+func (q *MmapQueue) readFraming(aid, offset int) (int, int, int, int, error) {
+	// check if length is present in same arena, if not get next arena.
+	// If length is stored in next arena, get next aid with 0 offset value.
+	if offset+cInt64Size > q.conf.arenaSize {
+		aid, offset = aid+1, 0
+	}
+
+	// read length
+	aa, err := q.am.getArena(aid)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	length := aa.ReadUint64At(int64(offset))
+	aid, offset = q.advance(aid, offset, cInt64Size)
+	if length&cEnvelopeFlag == 0 {
+		return aid, offset, int(length), 0, nil
+	}
+
+	// length of the envelope may be broken across arenas.
+	br := bytesReader{b: make([]byte, 0, cInt64Size)}
+	br.grow(cInt64Size)
+	aid, offset, err = q.readBytes(&br, aid, offset, cInt64Size)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+
+	total := int(length &^ cEnvelopeFlag)
+	envLen := int(binary.LittleEndian.Uint64(br.b))
+	if envLen < 0 || envLen > total-cInt64Size {
+		return 0, 0, 0, 0, fmt.Errorf("%w :: envelope at arena %d beyond record", ErrCorruptQueue, aid)
+	}
+
+	return aid, offset, total - cInt64Size - envLen, envLen, nil
+}
+
+// appendEnvelope encodes the key, timestamp and headers of the record and appends
+// them to b. Each field is stored as a tag, followed by the length of the value
+// and the value itself. Fields with unknown tags are skipped while decoding.
+func (r *Record) appendEnvelope(b []byte) []byte {
+	b = appendField(b, cTagTimestamp, binary.LittleEndian.AppendUint64(nil, uint64(r.Timestamp.UnixNano())))
+	if len(r.Key) > 0 {
+		b = appendField(b, cTagKey, r.Key)
+	}
+	if !r.ExpiresAt.IsZero() {
+		b = appendField(b, cTagExpiry, binary.LittleEndian.AppendUint64(nil, uint64(r.ExpiresAt.UnixNano())))
+	}
+	if !r.ReadyAt.IsZero() {
+		b = appendField(b, cTagReadyAt, binary.LittleEndian.AppendUint64(nil, uint64(r.ReadyAt.UnixNano())))
+	}
+
+	// headers are sorted so that the encoding is deterministic.
+	for _, k := range slices.Sorted(maps.Keys(r.Headers)) {
+		header := binary.AppendUvarint(nil, uint64(len(k)))
+		header = append(header, k...)
+		header = append(header, r.Headers[k]...)
+		b = appendField(b, cTagHeader, header)
+	}
+
+	return b
+}
+
+// decodeEnvelope decodes the fields of an envelope into the record.
+func (r *Record) decodeEnvelope(env []byte) error {
+	for len(env) > 0 {
+		tag := env[0]
+		n, size := binary.Uvarint(env[1:])
+		if size <= 0 || n > uint64(len(env)-1-size) {
+			return fmt.Errorf("%w :: invalid envelope field", ErrCorruptQueue)
+		}
+		value := env[1+size : 1+size+int(n)]
+		env = env[1+size+int(n):]
+
+		switch tag {
+		case cTagTimestamp:
+			if len(value) != cInt64Size {
+				return fmt.Errorf("%w :: invalid timestamp", ErrCorruptQueue)
+			}
+			r.Timestamp = time.Unix(0, int64(binary.LittleEndian.Uint64(value)))
+		case cTagKey:
+			r.Key = value
+		case cTagExpiry:
+			if len(value) != cInt64Size {
+				return fmt.Errorf("%w :: invalid expiry", ErrCorruptQueue)
+			}
+			r.ExpiresAt = time.Unix(0, int64(binary.LittleEndian.Uint64(value)))
+		case cTagReadyAt:
+			if len(value) != cInt64Size {
+				return fmt.Errorf("%w :: invalid ready time", ErrCorruptQueue)
+			}
+			r.ReadyAt = time.Unix(0, int64(binary.LittleEndian.Uint64(value)))
+		case cTagHeader:
+			kLen, size := binary.Uvarint(value)
+			if size <= 0 || kLen > uint64(len(value)-size) {
+				return fmt.Errorf("%w :: invalid header", ErrCorruptQueue)
+			}
+			if r.Headers == nil {
+				r.Headers = make(map[string]string)
+			}
+			k := value[size : size+int(kLen)]
+			r.Headers[string(k)] = string(value[size+int(kLen):])
+		}
+	}
+
+	return nil
+}
+
+// appendField appends a field of an envelope with given tag and value to b.
+func appendField(b []byte, tag byte, value []byte) []byte {
+	b = append(b, tag)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
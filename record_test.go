@@ -0,0 +1,101 @@
+package bigqueue
+
+import (
+	"bytes"
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	// length of the envelope of the next record is broken across arenas
+	plain := bytes.Repeat([]byte("p"), arenaSize-20)
+	if err := bq.Enqueue(plain); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	ts := time.Unix(1700000000, 123)
+	rec := Record{
+		Key:       []byte("key"),
+		Timestamp: ts,
+		Headers:   map[string]string{"content-type": "text/plain", "empty": ""},
+		Data:      bytes.Repeat([]byte("r"), arenaSize),
+	}
+	for range 3 {
+		if err := bq.EnqueueRecord(rec); err != nil {
+			t.Fatalf("EnqueueRecord failed :: %v", err)
+		}
+	}
+
+	before := time.Now()
+	if err := bq.EnqueueRecord(Record{Data: []byte("now")}); err != nil {
+		t.Fatalf("EnqueueRecord failed :: %v", err)
+	}
+
+	if err := bq.verifyRecords(); err != nil {
+		t.Fatalf("records should be framed correctly :: %v", err)
+	}
+
+	// plain elements are returned as records with just the data
+	if r, err := bq.DequeueRecord(); err != nil {
+		t.Fatalf("DequeueRecord failed :: %v", err)
+	} else if !bytes.Equal(r.Data, plain) || r.Key != nil || !r.Timestamp.IsZero() || r.Headers != nil {
+		t.Fatalf("unexpected record for a plain element")
+	}
+
+	r, err := bq.DequeueRecord()
+	if err != nil {
+		t.Fatalf("DequeueRecord failed :: %v", err)
+	}
+	if !bytes.Equal(r.Key, rec.Key) || !r.Timestamp.Equal(ts) ||
+		!maps.Equal(r.Headers, rec.Headers) || !bytes.Equal(r.Data, rec.Data) {
+		t.Fatalf("records don't match, key: %s, ts: %v, headers: %v", r.Key, r.Timestamp, r.Headers)
+	}
+
+	// other dequeue functions return just the data
+	if msg, err := bq.Peek(); err != nil {
+		t.Fatalf("Peek failed :: %v", err)
+	} else if !bytes.Equal(msg, rec.Data) {
+		t.Fatalf("Peek should return the data of the record")
+	}
+	if n, err := bq.NextLen(); err != nil || n != len(rec.Data) {
+		t.Fatalf("NextLen should return the length of the data, n: %d :: %v", n, err)
+	}
+	if err := bq.DequeueFunc(func(msg []byte) error {
+		if !bytes.Equal(msg, rec.Data) {
+			t.Fatalf("DequeueFunc should pass the data of the record")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("DequeueFunc failed :: %v", err)
+	}
+	if msg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if !bytes.Equal(msg, rec.Data) {
+		t.Fatalf("Dequeue should return the data of the record")
+	}
+
+	if r, err := bq.DequeueRecord(); err != nil {
+		t.Fatalf("DequeueRecord failed :: %v", err)
+	} else if string(r.Data) != "now" || r.Timestamp.Before(before) {
+		t.Fatalf("timestamp should be set upon enqueue, ts: %v", r.Timestamp)
+	}
+
+	if _, err := bq.DequeueRecord(); err != ErrEmptyQueue {
+		t.Fatalf("DequeueRecord should return empty queue error, returned: %v", err)
+	}
+}
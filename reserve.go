@@ -0,0 +1,147 @@
+package bigqueue
+
+import (
+	"errors"
+)
+
+// ErrReservationTooLarge is returned when a reservation would
+// span more arenas than are allowed to be in memory at once.
+var ErrReservationTooLarge = errors.New("reservation spans too many arenas")
+
+// Reservation is space for one element at the tail of the queue that can be
+// written directly, without copying the element. The element only becomes
+// visible to consumers once the reservation is committed. While a reservation
+// is open, all other writes to the queue wait for it to be committed or aborted.
+type Reservation struct {
+	q      *MmapQueue
+	bufs   [][]byte
+	arenas []*arena // arenas pinned in memory by the reservation
+	aid    int      // position right after the element
+	offset int
+	done   bool
+}
+
+// Reserve reserves space for an element of n bytes at the tail of the queue and
+// returns slices that alias the memory mapped arenas, in which the element is to
+// be written. The arenas stay pinned in memory until the reservation is committed
+// or aborted, hence, a reservation cannot span as many arenas as are allowed in
+// memory. The caller must commit or abort the reservation, other writes to the
+// queue block until then. The slices must not be used afterwards.
+func (q *MmapQueue) Reserve(n int) (*Reservation, error) {
+	if n < 0 {
+		return nil, ErrMessageSize
+	}
+
+	q.wlock.Lock()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	r, err := q.reserve(n)
+	if err != nil {
+		q.wlock.Unlock()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (q *MmapQueue) reserve(n int) (*Reservation, error) {
+	if q.isClosed() {
+		return nil, ErrQueueClosed
+	}
+
+	// length is never broken across arenas, it may start in the next arena.
+	tailAid, tailOffset := q.md.getTail()
+	aid, offset := tailAid, tailOffset
+	if offset+cInt64Size > q.conf.arenaSize {
+		aid, offset = aid+1, 0
+	}
+
+	endAid, endOffset := q.advance(aid, offset, cInt64Size+n)
+	lastAid := endAid
+	if endOffset == 0 {
+		lastAid--
+	}
+
+	// at least one arena, apart from the reserved ones, must be
+	// evictable so that the queue can still be read meanwhile.
+	if q.conf.maxInMemArenas > 0 && lastAid-tailAid+1 >= q.conf.maxInMemArenas {
+		return nil, ErrReservationTooLarge
+	}
+
+	r := &Reservation{q: q, aid: endAid, offset: endOffset}
+	aid, offset, err := q.writeLength(tailAid, tailOffset, uint64(n))
+	if err != nil {
+		return nil, err
+	}
+
+	for remaining := n; remaining > 0; {
+		aa, err := q.am.getArena(aid)
+		if err != nil {
+			r.unpin()
+			return nil, err
+		}
+		aa.pinned = true
+		r.arenas = append(r.arenas, aa)
+
+		chunk := min(remaining, q.conf.arenaSize-offset)
+		r.bufs = append(r.bufs, aa.view()[offset:offset+chunk:offset+chunk])
+		aid, offset = q.advance(aid, offset, chunk)
+		remaining -= chunk
+	}
+
+	return r, nil
+}
+
+// Bytes returns the slices in which the element is to be written, one slice per
+// arena that the element spans. The slices must be written in order, back to back.
+func (r *Reservation) Bytes() [][]byte {
+	return r.bufs
+}
+
+// Commit makes the element visible to consumers by moving the tail of the queue.
+func (r *Reservation) Commit() error {
+	if r.done {
+		return nil
+	}
+	r.done = true
+	defer r.q.wlock.Unlock()
+
+	r.q.lock.Lock()
+	defer r.q.lock.Unlock()
+
+	if r.q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	for _, aa := range r.arenas {
+		aa.markDirty()
+	}
+	r.unpin()
+
+	r.q.publish(r.aid, r.offset, 1)
+	return nil
+}
+
+// Abort discards the element. The tail of the queue is left untouched,
+// hence, the bytes written so far never become visible to consumers.
+func (r *Reservation) Abort() {
+	if r.done {
+		return
+	}
+	r.done = true
+
+	r.q.lock.Lock()
+	r.unpin()
+	r.q.lock.Unlock()
+	r.q.wlock.Unlock()
+}
+
+// unpin allows the arenas of the reservation to be evicted from memory again.
+func (r *Reservation) unpin() {
+	for _, aa := range r.arenas {
+		aa.pinned = false
+	}
+	r.arenas = nil
+	r.bufs = nil
+}
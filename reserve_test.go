@@ -0,0 +1,180 @@
+package bigqueue
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func fillReservation(r *Reservation, msg []byte) {
+	for _, buf := range r.Bytes() {
+		n := copy(buf, msg)
+		msg = msg[n:]
+	}
+}
+
+func TestReserve(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := os.Getpagesize() * 2
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize), SetMaxInMemArenas(3))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	// messages fill more arenas than allowed in memory
+	msgs := make([][]byte, 0, 10)
+	for i := range 10 {
+		msg := bytes.Repeat([]byte(strconv.Itoa(i)), arenaSize/2)
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	// reservation spans 2 arenas
+	msg := bytes.Repeat([]byte("r"), arenaSize)
+	r, err := bq.Reserve(len(msg))
+	if err != nil {
+		t.Fatalf("Reserve failed :: %v", err)
+	}
+	if len(r.Bytes()) != 2 {
+		t.Fatalf("reservation should span 2 arenas, spans: %d", len(r.Bytes()))
+	}
+
+	// reading older arenas evicts arenas from memory, except for the pinned ones
+	for i := range msgs {
+		if poppedMsg, err := bq.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue :: %v", err)
+		} else if !bytes.Equal(msgs[i], poppedMsg) {
+			t.Fatalf("messages don't match for element %d", i)
+		}
+	}
+	if !bq.IsEmpty() {
+		t.Fatalf("reserved message should not be visible before Commit")
+	}
+
+	fillReservation(r, msg)
+	if err := r.Commit(); err != nil {
+		t.Fatalf("Commit failed :: %v", err)
+	}
+
+	if poppedMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if !bytes.Equal(msg, poppedMsg) {
+		t.Fatalf("unequal messages")
+	}
+
+	// data written through reservations is synced upon flush
+	if err := bq.Flush(); err != nil {
+		t.Fatalf("unable to flush :: %v", err)
+	}
+}
+
+func TestReserveAbort(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := os.Getpagesize() * 2
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize), SetMaxInMemArenas(3))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if _, err := bq.Reserve(2 * arenaSize); err != ErrReservationTooLarge {
+		t.Fatalf("expected reservation too large error, returned: %v", err)
+	}
+
+	msg := []byte("reserved")
+	r, err := bq.Reserve(len(msg))
+	if err != nil {
+		t.Fatalf("Reserve failed :: %v", err)
+	}
+
+	// other writes wait for the reservation to be committed or aborted
+	done := make(chan error)
+	go func() {
+		done <- bq.EnqueueString("next")
+	}()
+
+	fillReservation(r, msg)
+	r.Abort()
+	if err := <-done; err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if poppedMsg, err := bq.DequeueString(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if poppedMsg != "next" {
+		t.Fatalf("aborted message should not be visible, dq: %s", poppedMsg)
+	}
+	if !bq.IsEmpty() {
+		t.Fatalf("BigQueue should be empty")
+	}
+}
+
+func TestReserveClose(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+
+	msg := []byte("reserved")
+	r, err := bq.Reserve(len(msg))
+	if err != nil {
+		t.Fatalf("Reserve failed :: %v", err)
+	}
+
+	// close waits for the reservation, the arenas stay mapped meanwhile
+	done := make(chan error, 1)
+	go func() {
+		done <- bq.Close()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("close should wait for the reservation, returned: %v", err)
+	default:
+	}
+
+	fillReservation(r, msg)
+	if err := r.Commit(); err != nil {
+		t.Fatalf("commit failed :: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	bq, err = NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if poppedMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if !bytes.Equal(msg, poppedMsg) {
+		t.Fatalf("committed message should survive close, dq: %s", poppedMsg)
+	}
+}
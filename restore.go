@@ -0,0 +1,360 @@
+package bigqueue
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+var (
+	// ErrIncompleteQueue is returned when a queue directory is missing
+	// the metadata file or any of the arenas between head and tail.
+	ErrIncompleteQueue = errors.New("incomplete queue directory")
+	// ErrCorruptQueue is returned when records or consumer offsets
+	// of a queue do not line up with its head and tail.
+	ErrCorruptQueue = errors.New("corrupt queue data")
+	// ErrQueueExists is returned when a queue is restored into
+	// a directory that already contains a queue.
+	ErrQueueExists = errors.New("queue already exists")
+)
+
+// restoreConfig stores all the configuration related to a restore.
+type restoreConfig struct {
+	verifyRecords  bool
+	resetConsumers bool
+}
+
+// RestoreOption is function type that takes a restoreConfig object
+// and sets various restore parameters in the object.
+type RestoreOption func(*restoreConfig)
+
+// RestoreVerifyRecords returns a RestoreOption that walks every record from
+// head to tail after the copy and ensures that the records are framed correctly
+// and that every consumer points at a record boundary. Records do not carry
+// checksums, hence, the payload itself is not verified.
+func RestoreVerifyRecords() RestoreOption {
+	return func(c *restoreConfig) {
+		c.verifyRecords = true
+	}
+}
+
+// RestoreResetConsumers returns a RestoreOption that moves
+// every consumer of the restored queue to the head of the queue.
+func RestoreResetConsumers() RestoreOption {
+	return func(c *restoreConfig) {
+		c.resetConsumers = true
+	}
+}
+
+// Restore copies a queue from srcDir into dstDir, typically from a snapshot
+// or an archive of a queue directory. Before the queue can be opened, it
+// validates the metadata version, the arena size and the presence of every
+// arena from head to tail. Offsets of all the consumers must lie between head
+// and tail unless they are reset using RestoreResetConsumers. dstDir must
+// already exist and must not contain a queue. If validation fails, all the
+// files copied into dstDir are removed again. The restored queue keeps the
+// ID of the source queue. Elements delayed using EnqueueAt are restored as well.
+func Restore(srcDir, dstDir string, opts ...RestoreOption) error {
+	complete := false
+
+	conf := &restoreConfig{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+
+	srcMeta := filepath.Join(srcDir, cMetadataFileName)
+	if _, err := os.Stat(srcMeta); os.IsNotExist(err) {
+		return fmt.Errorf("%w :: metadata file not found", ErrIncompleteQueue)
+	} else if err != nil {
+		return fmt.Errorf("error in reading metadata file :: %w", err)
+	}
+
+	dstMeta := filepath.Join(dstDir, cMetadataFileName)
+	if _, err := os.Stat(dstMeta); err == nil {
+		return ErrQueueExists
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error in reading metadata file :: %w", err)
+	}
+
+	var copied []string
+	defer func() {
+		if !complete {
+			for _, file := range slices.Backward(copied) {
+				_ = os.Remove(file)
+			}
+		}
+	}()
+
+	// metadata is copied first so that we validate the copy,
+	// the source is never mapped or modified by a restore.
+	if err := copyFile(srcMeta, dstMeta); err != nil {
+		return err
+	}
+	copied = append(copied, dstMeta)
+
+	// the time index is optional, seeking falls back to scanning without it.
+	srcIndex := filepath.Join(srcDir, cIndexFileName)
+	if _, err := os.Stat(srcIndex); err == nil {
+		dstIndex := filepath.Join(dstDir, cIndexFileName)
+		if err := copyFile(srcIndex, dstIndex); err != nil {
+			return err
+		}
+		copied = append(copied, dstIndex)
+	}
+
+	// elements received from consumers are delivered again if the consumers are reset.
+	srcInflight := filepath.Join(srcDir, cInflightFileName)
+	if _, err := os.Stat(srcInflight); err == nil && !conf.resetConsumers {
+		dstInflight := filepath.Join(dstDir, cInflightFileName)
+		if err := copyFile(srcInflight, dstInflight); err != nil {
+			return err
+		}
+		copied = append(copied, dstInflight)
+	}
+
+	arenaSize, err := restoreMetadata(srcDir, dstDir, conf, &copied)
+	if err != nil {
+		return err
+	}
+
+	if conf.verifyRecords {
+		if err := verifyQueue(dstDir, arenaSize); err != nil {
+			return err
+		}
+	}
+
+	if err := restoreDelayed(srcDir, dstDir, conf, &copied); err != nil {
+		return err
+	}
+
+	complete = true
+	return nil
+}
+
+// restoreMetadata copies all the arenas, validates the copied
+// metadata and fixes up the offsets of consumers.
+func restoreMetadata(srcDir, dstDir string, conf *restoreConfig, copied *[]string) (int, error) {
+	// arenas are copied before the metadata is loaded because
+	// migrating the metadata to the current version may read them.
+	arenaSize, err := restoreArenas(srcDir, dstDir, copied)
+	if err != nil {
+		return 0, err
+	}
+
+	md, err := newMetadata(dstDir, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = md.close() }()
+
+	headAid, headOffset := md.getHead()
+	tailAid, tailOffset := md.getTail()
+	for name, base := range md.co {
+		if conf.resetConsumers {
+			md.putConsumerHead(base, headAid, headOffset)
+			md.putConsumerSeq(base, md.getHeadSeq())
+			continue
+		}
+
+		aid, offset := md.getConsumerHead(base)
+		if comparePos(aid, offset, headAid, headOffset) < 0 ||
+			comparePos(aid, offset, tailAid, tailOffset) > 0 ||
+			md.getConsumerSeq(base) > md.getNextSeq() {
+			return 0, fmt.Errorf("%w :: consumer %s is out of range", ErrCorruptQueue, name)
+		}
+	}
+
+	return arenaSize, nil
+}
+
+// restoreArenas copies all the arenas from head to tail of the queue. It reads the
+// copied metadata without loading it, head, tail and arena size are stored at the
+// same offsets in all the versions of the metadata.
+func restoreArenas(srcDir, dstDir string, copied *[]string) (int, error) {
+	metaPath := filepath.Join(dstDir, cMetadataFileName)
+	info, err := os.Stat(metaPath)
+	if err != nil {
+		return 0, fmt.Errorf("error in reading metadata file :: %w", err)
+	} else if info.Size() < 6*cInt64Size {
+		return 0, fmt.Errorf("%w :: metadata file is truncated", ErrIncompleteQueue)
+	}
+
+	aa, err := newArena(metaPath, int(info.Size()))
+	if err != nil {
+		return 0, fmt.Errorf("error in creating arena for metadata file :: %w", err)
+	}
+	md := &metadata{aa: aa, file: metaPath, size: info.Size()}
+	defer func() { _ = md.close() }()
+
+	arenaSize := md.getArenaSize()
+	if arenaSize <= 0 {
+		return 0, ErrInvalidArenaSize
+	}
+
+	headAid, headOffset := md.getHead()
+	tailAid, tailOffset := md.getTail()
+	if comparePos(headAid, headOffset, tailAid, tailOffset) > 0 {
+		return 0, fmt.Errorf("%w :: head is ahead of tail", ErrCorruptQueue)
+	}
+
+	for aid := headAid; aid <= tailAid; aid++ {
+		name := strconv.Itoa(aid) + cArenaFileSuffix
+		info, err := os.Stat(filepath.Join(srcDir, name))
+		switch {
+		case os.IsNotExist(err) && aid == tailAid && tailOffset == 0:
+			// tail arena is only created once data is written to it.
+			continue
+		case os.IsNotExist(err):
+			return 0, fmt.Errorf("%w :: arena %d not found", ErrIncompleteQueue, aid)
+		case err != nil:
+			return 0, fmt.Errorf("error in reading arena file :: %w", err)
+		case info.Size() < int64(arenaSize):
+			return 0, fmt.Errorf("%w :: arena %d is truncated", ErrIncompleteQueue, aid)
+		}
+
+		dst := filepath.Join(dstDir, name)
+		if err := copyFile(filepath.Join(srcDir, name), dst); err != nil {
+			return 0, err
+		}
+		*copied = append(*copied, dst)
+	}
+
+	return arenaSize, nil
+}
+
+// restoreDelayed restores the delay queue, if any, which is a queue of its own
+// in the delayed directory. Its consumers are never reset, elements before its
+// head have already been moved to the queue.
+func restoreDelayed(srcDir, dstDir string, conf *restoreConfig, copied *[]string) error {
+	srcDelayed := filepath.Join(srcDir, cDelayedDir)
+	if _, err := os.Stat(srcDelayed); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error in reading delay queue directory :: %w", err)
+	}
+
+	dstDelayed := filepath.Join(dstDir, cDelayedDir)
+	if err := os.Mkdir(dstDelayed, cFilePerm); err != nil {
+		return fmt.Errorf("error in creating delay queue directory :: %w", err)
+	}
+	*copied = append(*copied, dstDelayed)
+
+	promoted := filepath.Join(dstDelayed, cPromotedFileName)
+	if err := copyFile(filepath.Join(srcDelayed, cPromotedFileName), promoted); err != nil {
+		return err
+	}
+	*copied = append(*copied, promoted)
+
+	var opts []RestoreOption
+	if conf.verifyRecords {
+		opts = append(opts, RestoreVerifyRecords())
+	}
+	if err := Restore(srcDelayed, dstDelayed, opts...); err != nil {
+		return fmt.Errorf("error in restoring delay queue :: %w", err)
+	}
+
+	return nil
+}
+
+// verifyQueue opens the queue in dir and walks all the records
+// from head to tail to ensure that they are framed correctly.
+func verifyQueue(dir string, arenaSize int) error {
+	q, err := NewMmapQueue(dir, SetArenaSize(arenaSize),
+		SetPeriodicFlushOps(0), SetPeriodicFlushDuration(0))
+	if err != nil {
+		return err
+	}
+
+	if err := q.verifyRecords(); err != nil {
+		_ = q.Close()
+		return err
+	}
+
+	return q.Close()
+}
+
+// verifyRecords walks all the records from head to tail and ensures that each record
+// ends before the tail and that every consumer head is a record boundary. It also
+// ensures that the sequence numbers match with the number of records walked.
+func (q *MmapQueue) verifyRecords() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	heads := make(map[[2]int][]string, len(q.md.co))
+	for name, base := range q.md.co {
+		aid, offset := q.md.getConsumerHead(base)
+		heads[[2]int{aid, offset}] = append(heads[[2]int{aid, offset}], name)
+	}
+
+	aid, offset := q.md.getHead()
+	seq := q.md.getHeadSeq()
+	tailAid, tailOffset := q.md.getTail()
+	for {
+		for _, name := range heads[[2]int{aid, offset}] {
+			if q.md.getConsumerSeq(q.md.co[name]) != seq {
+				return fmt.Errorf("%w :: sequence of consumer %s doesn't match", ErrCorruptQueue, name)
+			}
+		}
+		delete(heads, [2]int{aid, offset})
+		if aid == tailAid && offset == tailOffset {
+			break
+		}
+
+		// length must be present before the tail, in the same arena.
+		if offset+cInt64Size > q.conf.arenaSize && aid == tailAid {
+			return fmt.Errorf("%w :: length at arena %d beyond tail", ErrCorruptQueue, aid)
+		}
+
+		newAid, newOffset, length, err := q.readLength(aid, offset)
+		if err != nil {
+			return err
+		}
+
+		if length < 0 || length > q.distance(newAid, newOffset, tailAid, tailOffset) {
+			return fmt.Errorf("%w :: record at arena %d beyond tail", ErrCorruptQueue, newAid)
+		}
+		aid, offset = q.advance(newAid, newOffset, length)
+		seq++
+	}
+
+	if len(heads) != 0 {
+		names := slices.Concat(slices.Collect(maps.Values(heads))...)
+		return fmt.Errorf("%w :: consumer %s is not at a record boundary", ErrCorruptQueue, slices.Min(names))
+	}
+
+	if q.md.getNextSeq() != seq {
+		return fmt.Errorf("%w :: sequence of tail doesn't match", ErrCorruptQueue)
+	}
+
+	return nil
+}
+
+// copyFile copies the file at src into a new file at dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error in opening file :: %w", err)
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, cFilePerm)
+	if err != nil {
+		return fmt.Errorf("error in creating file :: %w", err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("error in copying file :: %w", err)
+	}
+
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error in closing file :: %w", err)
+	}
+
+	return nil
+}
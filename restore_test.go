@@ -0,0 +1,214 @@
+package bigqueue
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func setupRestoreQueue(t *testing.T, arenaSize int) (string, [][]byte) {
+	t.Helper()
+
+	srcDir := t.TempDir()
+	bq, err := NewMmapQueue(srcDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+
+	// messages are spread over multiple arenas
+	msgs := make([][]byte, 0, 10)
+	for i := range 10 {
+		msg := bytes.Repeat([]byte(strconv.Itoa(i)), arenaSize/3)
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	for range 4 {
+		if _, err := c.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue from consumer :: %v", err)
+		}
+	}
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	return srcDir, msgs
+}
+
+func TestRestore(t *testing.T) {
+	t.Parallel()
+
+	arenaSize := 8 * 1024
+	srcDir, msgs := setupRestoreQueue(t, arenaSize)
+	dstDir := t.TempDir()
+	if err := Restore(srcDir, dstDir, RestoreVerifyRecords()); err != nil {
+		t.Fatalf("unable to restore queue :: %v", err)
+	}
+
+	bq, err := NewMmapQueue(dstDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	for _, msg := range msgs[4:] {
+		if poppedMsg, err := c.Dequeue(); err != nil {
+			t.Fatalf("unable to dequeue from consumer :: %v", err)
+		} else if !bytes.Equal(msg, poppedMsg) {
+			t.Fatalf("unequal messages, eq: %s, dq: %s", string(msg), string(poppedMsg))
+		}
+	}
+
+	if !c.IsEmpty() {
+		t.Fatalf("BigQueue should be empty for consumer")
+	}
+}
+
+func TestRestoreResetConsumers(t *testing.T) {
+	t.Parallel()
+
+	arenaSize := 8 * 1024
+	srcDir, msgs := setupRestoreQueue(t, arenaSize)
+	dstDir := t.TempDir()
+	if err := Restore(srcDir, dstDir, RestoreResetConsumers()); err != nil {
+		t.Fatalf("unable to restore queue :: %v", err)
+	}
+
+	bq, err := NewMmapQueue(dstDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	if poppedMsg, err := c.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue from consumer :: %v", err)
+	} else if !bytes.Equal(msgs[0], poppedMsg) {
+		t.Fatalf("consumer should be reset to head, dq: %s", string(poppedMsg))
+	}
+}
+
+func TestRestoreMissingArena(t *testing.T) {
+	t.Parallel()
+
+	srcDir, _ := setupRestoreQueue(t, 8*1024)
+	if err := os.Remove(filepath.Join(srcDir, "1"+cArenaFileSuffix)); err != nil {
+		t.Fatalf("unable to remove arena :: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	if err := Restore(srcDir, dstDir); !errors.Is(err, ErrIncompleteQueue) {
+		t.Fatalf("expected incomplete queue error, returned: %v", err)
+	}
+
+	if files, err := os.ReadDir(dstDir); err != nil {
+		t.Fatalf("unable to read dir :: %v", err)
+	} else if len(files) != 0 {
+		t.Fatalf("copied files should be removed on failure, found: %v", len(files))
+	}
+}
+
+func TestRestoreMissingMetadata(t *testing.T) {
+	t.Parallel()
+
+	if err := Restore(t.TempDir(), t.TempDir()); !errors.Is(err, ErrIncompleteQueue) {
+		t.Fatalf("expected incomplete queue error, returned: %v", err)
+	}
+}
+
+func TestRestoreExistingQueue(t *testing.T) {
+	t.Parallel()
+
+	arenaSize := 8 * 1024
+	srcDir, _ := setupRestoreQueue(t, arenaSize)
+	if err := Restore(srcDir, srcDir); err != ErrQueueExists {
+		t.Fatalf("expected queue exists error, returned: %v", err)
+	}
+}
+
+func TestRestoreCorruptRecord(t *testing.T) {
+	t.Parallel()
+
+	arenaSize := 8 * 1024
+	srcDir, _ := setupRestoreQueue(t, arenaSize)
+
+	// overwrite the length of the first record with a huge value
+	fd, err := os.OpenFile(filepath.Join(srcDir, "0"+cArenaFileSuffix), os.O_RDWR, cFilePerm)
+	if err != nil {
+		t.Fatalf("unable to open arena :: %v", err)
+	}
+	if _, err := fd.WriteAt(bytes.Repeat([]byte{0x7f}, cInt64Size), 0); err != nil {
+		t.Fatalf("unable to corrupt arena :: %v", err)
+	}
+	if err := fd.Close(); err != nil {
+		t.Fatalf("unable to close arena :: %v", err)
+	}
+
+	if err := Restore(srcDir, t.TempDir(), RestoreVerifyRecords()); !errors.Is(err, ErrCorruptQueue) {
+		t.Fatalf("expected corrupt queue error, returned: %v", err)
+	}
+
+	// without verification, framing of records is not checked
+	if err := Restore(srcDir, t.TempDir()); err != nil {
+		t.Fatalf("unable to restore queue :: %v", err)
+	}
+}
+
+func TestRestoreOldVersion(t *testing.T) {
+	t.Parallel()
+
+	arenaSize := 8 * 1024
+	srcDir, msgs := setupRestoreQueue(t, arenaSize)
+	writeOldMetadata(t, srcDir, 2)
+
+	// metadata is migrated after the arenas are copied
+	dstDir := t.TempDir()
+	if err := Restore(srcDir, dstDir, RestoreVerifyRecords()); err != nil {
+		t.Fatalf("unable to restore queue :: %v", err)
+	}
+
+	bq, err := NewMmapQueue(dstDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+	if msg, err := c.DequeueMsg(); err != nil {
+		t.Fatalf("unable to dequeue from consumer :: %v", err)
+	} else if msg.Seq != 4 || !bytes.Equal(msg.Data, msgs[4]) {
+		t.Fatalf("unexpected message after restore, seq: %d", msg.Seq)
+	}
+}
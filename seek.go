@@ -0,0 +1,131 @@
+package bigqueue
+
+// SeekToStart moves the head of the queue back to the first element of the queue,
+// hence, all the elements ever enqueued are dequeued again. This function uses the
+// default consumer to consume from the queue.
+func (q *MmapQueue) SeekToStart() error {
+	return q.seekToStart(q.dc)
+}
+
+func (q *MmapQueue) seekToStart(base int64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	aid, offset := q.md.getHead()
+	q.putConsumerHead(base, aid, offset)
+	q.putConsumerSeq(base, q.md.getHeadSeq())
+	q.incrMutOps()
+	q.notifyEnqueue()
+	return nil
+}
+
+// SeekToEnd moves the head of the queue to the tail of the queue, hence, all the
+// elements in the queue are skipped and only elements enqueued afterwards are
+// dequeued. This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) SeekToEnd() error {
+	return q.seekToEnd(q.dc)
+}
+
+func (q *MmapQueue) seekToEnd(base int64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	aid, offset := q.md.getTail()
+	q.putConsumerHead(base, aid, offset)
+	q.putConsumerSeq(base, q.md.getNextSeq())
+	q.incrMutOps()
+	return nil
+}
+
+// Seek moves the head of the queue to the given position, the head may also move
+// backwards. The position must be obtained from this queue, for example, using
+// Position, EnqueueWithPosition or Scan, otherwise ErrInvalidPosition is returned.
+// The zero value of Position moves the head to the first element of the queue.
+// This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) Seek(pos Position) error {
+	return q.seek(q.dc, pos)
+}
+
+func (q *MmapQueue) seek(base int64, pos Position) error {
+	if pos == (Position{}) {
+		return q.seekToStart(base)
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	if pos.id != q.id {
+		return ErrInvalidPosition
+	}
+	if err := q.verifyPosition(pos); err != nil {
+		return err
+	}
+
+	q.putConsumerHead(base, pos.aid, pos.offset)
+	q.putConsumerSeq(base, pos.seq)
+	q.incrMutOps()
+	q.notifyEnqueue()
+	return nil
+}
+
+// verifyPosition ensures that the position is the start of an element, or the tail
+// of the queue, and that its sequence number matches. Elements are walked starting
+// from the last indexed element at or before the position, see timeIndex.
+func (q *MmapQueue) verifyPosition(pos Position) error {
+	tailAid, tailOffset := q.md.getTail()
+	if comparePos(pos.aid, pos.offset, tailAid, tailOffset) > 0 {
+		return ErrInvalidPosition
+	}
+
+	aid, offset := q.md.getHead()
+	seq := q.md.getHeadSeq()
+	if e, ok := q.ti.atOrBefore(pos.aid, pos.offset); ok {
+		aid, offset, seq = e.aid, e.offset, e.seq
+	}
+
+	for comparePos(aid, offset, pos.aid, pos.offset) < 0 {
+		_, dataAid, dataOffset, length, err := q.readEnvelope(aid, offset)
+		if err != nil {
+			return err
+		}
+
+		aid, offset = q.advance(dataAid, dataOffset, length)
+		seq++
+	}
+
+	if aid != pos.aid || offset != pos.offset || seq != pos.seq {
+		return ErrInvalidPosition
+	}
+	return nil
+}
+
+// Position returns the position of the head of the queue, which can be
+// passed to Seek later on, e.g. after the queue is reopened, to move
+// the head back to it. This function uses the default consumer.
+func (q *MmapQueue) Position() (Position, error) {
+	return q.position(q.dc)
+}
+
+func (q *MmapQueue) position(base int64) (Position, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		return Position{}, ErrQueueClosed
+	}
+
+	aid, offset := q.getConsumerHead(base)
+	return Position{id: q.id, aid: aid, offset: offset, seq: q.getConsumerSeq(base)}, nil
+}
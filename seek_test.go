@@ -0,0 +1,144 @@
+package bigqueue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeek(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(4*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+
+	// elements span multiple arenas so that the index is used
+	elem := func(i int) string { return strconv.Itoa(i) + strings.Repeat("x", 1000) }
+	for i := range 20 {
+		if err := bq.EnqueueString(elem(i)); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	dequeue := func(c *Consumer, want ...int) {
+		t.Helper()
+
+		for _, i := range want {
+			if msg, err := c.DequeueString(); err != nil || msg != elem(i) {
+				t.Fatalf("expected element %d, got: %.4s :: %v", i, msg, err)
+			}
+		}
+	}
+
+	dequeue(c, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12)
+	pos, err := c.Position()
+	if err != nil || pos.Seq() != 13 {
+		t.Fatalf("position should be at element 13, seq: %d :: %v", pos.Seq(), err)
+	}
+
+	if err := c.SeekToEnd(); err != nil {
+		t.Fatalf("seek to end failed :: %v", err)
+	}
+	if !c.IsEmpty() {
+		t.Fatalf("consumer should be empty after seek to end")
+	}
+	if err := c.SeekToStart(); err != nil {
+		t.Fatalf("seek to start failed :: %v", err)
+	}
+	dequeue(c, 0, 1)
+
+	// invalid positions leave the head unchanged
+	mid, wrongSeq, beyond, other := pos, pos, pos, pos
+	mid.offset++
+	wrongSeq.seq++
+	beyond.aid += 10
+	other.id[0]++
+	for _, p := range []Position{mid, wrongSeq, beyond, other} {
+		if err := c.Seek(p); err != ErrInvalidPosition {
+			t.Fatalf("expected error: %v, got: %v", ErrInvalidPosition, err)
+		}
+	}
+	dequeue(c, 2)
+
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+	if err := c.SeekToStart(); err != ErrQueueClosed {
+		t.Fatalf("expected error: %v, got: %v", ErrQueueClosed, err)
+	}
+
+	// positions stay valid after the queue is reopened
+	bq, err = NewMmapQueue(testDir, SetArenaSize(4*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if err := bq.Seek(pos); err != nil {
+		t.Fatalf("seek failed :: %v", err)
+	}
+	if msg, err := bq.DequeueString(); err != nil || msg != elem(13) {
+		t.Fatalf("expected element 13, got: %.4s :: %v", msg, err)
+	}
+	if err := bq.Seek(Position{}); err != nil {
+		t.Fatalf("seek failed :: %v", err)
+	}
+	if msg, err := bq.DequeueString(); err != nil || msg != elem(0) {
+		t.Fatalf("expected element 0, got: %.4s :: %v", msg, err)
+	}
+}
+
+func TestSeekWakesWaiters(t *testing.T) {
+	t.Parallel()
+
+	bq, err := NewMmapQueue(t.TempDir(), SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if err := bq.EnqueueString("elem"); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+	if _, err := bq.Dequeue(); err != nil {
+		t.Fatalf("dequeue failed :: %v", err)
+	}
+
+	// a consumer waiting on a drained queue is woken up when its head moves backwards
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		msg, err := bq.DequeueWait(ctx)
+		if err == nil && string(msg) != "elem" {
+			err = fmt.Errorf("expected elem, got: %s", msg)
+		}
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := bq.SeekToStart(); err != nil {
+		t.Fatalf("seek to start failed :: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("DequeueWait failed :: %v", err)
+	}
+}
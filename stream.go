@@ -0,0 +1,242 @@
+package bigqueue
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+var (
+	// ErrStreamClosed is returned when a streaming reader
+	// or writer is used after it has been closed.
+	ErrStreamClosed = errors.New("stream is closed")
+	// ErrHeadMoved is returned when the head of a consumer is moved by
+	// another operation while an element was being read by a stream.
+	ErrHeadMoved = errors.New("head of the consumer moved while reading")
+	// ErrMessageSize is returned when the number of bytes written
+	// to a MessageWriter doesn't match the size of the element.
+	ErrMessageSize = errors.New("written bytes don't match size of the element")
+)
+
+// messageReader streams one element of the queue arena by arena. The head of
+// the consumer is only updated when the reader is closed after reading the whole element.
+type messageReader struct {
+	q         *MmapQueue
+	base      int64
+	headAid   int // head of the consumer when the reader was created
+	headPos   int
+	aid       int // position of the next byte to read
+	offset    int
+	remaining int
+	closed    bool
+}
+
+// DequeueReader returns a reader that streams the element at the head of the
+// queue without loading the whole element into memory. The element is removed
+// from the queue when the reader is closed after it has been read until io.EOF.
+// If the reader is closed before that or is abandoned, the element stays in the
+// queue. This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) DequeueReader() (io.ReadCloser, error) {
+	return q.dequeueStream(q.dc)
+}
+
+func (q *MmapQueue) dequeueStream(base int64) (io.ReadCloser, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		return nil, ErrQueueClosed
+	}
+	if err := q.dropExpired(base); err != nil {
+		return nil, err
+	}
+	if q.isEmptyNoLock(base) {
+		return nil, ErrEmptyQueue
+	}
+
+	headAid, headPos := q.getConsumerHead(base)
+	aid, offset, length, err := q.readLength(headAid, headPos)
+	if err != nil {
+		return nil, err
+	}
+
+	return &messageReader{
+		q:         q,
+		base:      base,
+		headAid:   headAid,
+		headPos:   headPos,
+		aid:       aid,
+		offset:    offset,
+		remaining: length,
+	}, nil
+}
+
+// Read reads the element into p, at most up to the end of the current arena.
+func (r *messageReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, ErrStreamClosed
+	}
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	r.q.lock.Lock()
+	defer r.q.lock.Unlock()
+
+	if r.q.isClosed() {
+		return 0, ErrQueueClosed
+	}
+
+	aa, err := r.q.am.getArena(r.aid)
+	if err != nil {
+		return 0, err
+	}
+
+	n := min(len(p), r.remaining, r.q.conf.arenaSize-r.offset)
+	n, _ = aa.ReadAt(p[:n], int64(r.offset))
+	r.aid, r.offset = r.q.advance(r.aid, r.offset, n)
+	r.remaining -= n
+	return n, nil
+}
+
+// Close removes the element from the queue if it has been read completely.
+func (r *messageReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	// element is not read completely, we leave the head untouched.
+	if r.remaining != 0 {
+		return nil
+	}
+
+	r.q.lock.Lock()
+	defer r.q.lock.Unlock()
+
+	if r.q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	if aid, offset := r.q.getConsumerHead(r.base); aid != r.headAid || offset != r.headPos {
+		return ErrHeadMoved
+	}
+
+	r.q.moveHead(r.base, r.aid, r.offset, 1)
+	return nil
+}
+
+// MessageWriter streams one element of known size into the queue arena by arena.
+// The element only becomes visible to consumers when the writer is closed after
+// writing exactly as many bytes as the size of the element. While a MessageWriter
+// is open, all other writes to the queue wait for it to be closed or aborted.
+type MessageWriter struct {
+	q         *MmapQueue
+	aid       int // position of the next byte to write
+	offset    int
+	remaining int
+	closed    bool
+}
+
+// NewMessageWriter returns a writer that streams an element of given size into the
+// queue without holding the whole element in memory. The length of the element is
+// written right away, the content is written as the caller writes to the returned
+// writer, and the tail of the queue is only moved when the writer is closed. The
+// caller must close or abort the writer, other writes to the queue block until then.
+func (q *MmapQueue) NewMessageWriter(size int64) (*MessageWriter, error) {
+	if size < 0 || size > math.MaxInt {
+		return nil, ErrMessageSize
+	}
+
+	q.wlock.Lock()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		q.wlock.Unlock()
+		return nil, ErrQueueClosed
+	}
+
+	aid, offset := q.md.getTail()
+	aid, offset, err := q.writeLength(aid, offset, uint64(size))
+	if err != nil {
+		q.wlock.Unlock()
+		return nil, err
+	}
+
+	return &MessageWriter{
+		q:         q,
+		aid:       aid,
+		offset:    offset,
+		remaining: int(size),
+	}, nil
+}
+
+// Write writes p into the queue. ErrMessageSize is returned if writing
+// p would exceed the size of the element, the extra bytes are not written.
+func (w *MessageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrStreamClosed
+	}
+
+	n := min(len(p), w.remaining)
+	if n > 0 {
+		w.q.lock.Lock()
+		if w.q.isClosed() {
+			w.q.lock.Unlock()
+			return 0, ErrQueueClosed
+		}
+
+		w.q.bw.b = p[:n]
+		aid, offset, err := w.q.writeBytes(&w.q.bw, w.aid, w.offset)
+		w.q.bw.b = nil
+		w.q.lock.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		w.aid, w.offset = aid, offset
+		w.remaining -= n
+	}
+
+	if n < len(p) {
+		return n, ErrMessageSize
+	}
+
+	return n, nil
+}
+
+// Close makes the element visible to consumers by moving the tail of the queue.
+// If fewer bytes than the size of the element have been written, the element is
+// discarded as if the writer was aborted and ErrMessageSize is returned.
+func (w *MessageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.q.wlock.Unlock()
+
+	if w.remaining != 0 {
+		return ErrMessageSize
+	}
+
+	w.q.lock.Lock()
+	defer w.q.lock.Unlock()
+
+	if w.q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	w.q.publish(w.aid, w.offset, 1)
+	return nil
+}
+
+// Abort discards the element. The tail of the queue is left untouched,
+// hence, the bytes written so far never become visible to consumers.
+func (w *MessageWriter) Abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.q.wlock.Unlock()
+}
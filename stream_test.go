@@ -0,0 +1,239 @@
+package bigqueue
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDequeueReader(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := os.Getpagesize() * 2
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize), SetMaxInMemArenas(3))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if _, err := bq.DequeueReader(); err != ErrEmptyQueue {
+		t.Fatalf("DequeueReader should return empty queue error, returned: %v", err)
+	}
+
+	// message spans across more arenas than allowed in memory
+	msg := bytes.Repeat([]byte("abcdefgh"), arenaSize)
+	if err := bq.Enqueue(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+	if err := bq.EnqueueString("next"); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	r, err := bq.DequeueReader()
+	if err != nil {
+		t.Fatalf("DequeueReader failed :: %v", err)
+	}
+
+	var out bytes.Buffer
+	if _, err := io.CopyBuffer(&out, r, make([]byte, 1000)); err != nil {
+		t.Fatalf("unable to read from reader :: %v", err)
+	}
+	if !bytes.Equal(msg, out.Bytes()) {
+		t.Fatalf("streamed and enqueued messages are not equal")
+	}
+	checkInMemArenaInvariant(t, bq)
+
+	// head is only moved on close
+	if headMsg, err := bq.Peek(); err != nil {
+		t.Fatalf("Peek failed :: %v", err)
+	} else if !bytes.Equal(msg, headMsg) {
+		t.Fatalf("head should not move before the reader is closed")
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("unable to close reader :: %v", err)
+	}
+	if _, err := r.Read(make([]byte, 10)); err != ErrStreamClosed {
+		t.Fatalf("expected stream closed error, returned: %v", err)
+	}
+
+	if headMsg, err := bq.DequeueString(); err != nil {
+		t.Fatalf("DequeueString failed :: %v", err)
+	} else if headMsg != "next" {
+		t.Fatalf("unequal messages, eq: next, dq: %s", headMsg)
+	}
+}
+
+func TestDequeueReaderAbandon(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg := []byte("abcdefghij")
+	if err := bq.Enqueue(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+
+	// partially read element stays in the queue
+	r, err := c.DequeueReader()
+	if err != nil {
+		t.Fatalf("DequeueReader failed :: %v", err)
+	}
+	if n, err := r.Read(make([]byte, 4)); err != nil || n != 4 {
+		t.Fatalf("unable to read from reader, n: %v :: %v", n, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("unable to close reader :: %v", err)
+	}
+	if c.IsEmpty() {
+		t.Fatalf("element should stay in the queue")
+	}
+
+	// head moved by another read while streaming
+	r, err = c.DequeueReader()
+	if err != nil {
+		t.Fatalf("DequeueReader failed :: %v", err)
+	}
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("unable to read from reader :: %v", err)
+	}
+	if headMsg, err := c.Dequeue(); err != nil {
+		t.Fatalf("Dequeue failed :: %v", err)
+	} else if !bytes.Equal(msg, headMsg) {
+		t.Fatalf("messages don't match")
+	}
+	if err := r.Close(); err != ErrHeadMoved {
+		t.Fatalf("expected head moved error, returned: %v", err)
+	}
+}
+
+func TestMessageWriter(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := os.Getpagesize() * 2
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize), SetMaxInMemArenas(3))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	// message spans across more arenas than allowed in memory
+	msg := bytes.Repeat([]byte("abcdefgh"), arenaSize)
+	w, err := bq.NewMessageWriter(int64(len(msg)))
+	if err != nil {
+		t.Fatalf("NewMessageWriter failed :: %v", err)
+	}
+
+	// other writes wait for the writer to be closed
+	done := make(chan error)
+	go func() {
+		done <- bq.EnqueueString("next")
+	}()
+
+	if n, err := io.Copy(w, bytes.NewReader(msg)); err != nil || n != int64(len(msg)) {
+		t.Fatalf("unable to write message, n: %d :: %v", n, err)
+	}
+	if !bq.IsEmpty() {
+		t.Fatalf("message should not be visible before Close")
+	}
+	if n, err := w.Write([]byte("extra")); err != ErrMessageSize || n != 0 {
+		t.Fatalf("expected message size error, n: %d, returned: %v", n, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unable to close writer :: %v", err)
+	}
+	if _, err := w.Write(nil); err != ErrStreamClosed {
+		t.Fatalf("expected stream closed error, returned: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+
+	if poppedMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if !bytes.Equal(msg, poppedMsg) {
+		t.Fatalf("unequal messages")
+	}
+	if poppedMsg, err := bq.DequeueString(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if poppedMsg != "next" {
+		t.Fatalf("unequal messages, exp: next, actual: %s", poppedMsg)
+	}
+}
+
+func TestMessageWriterAbort(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := os.Getpagesize() * 2
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	msg := bytes.Repeat([]byte("abcdefgh"), arenaSize/2)
+	w, err := bq.NewMessageWriter(int64(len(msg)))
+	if err != nil {
+		t.Fatalf("NewMessageWriter failed :: %v", err)
+	}
+	if _, err := w.Write(msg[:arenaSize]); err != nil {
+		t.Fatalf("unable to write message :: %v", err)
+	}
+	w.Abort()
+
+	// closing a writer that is not written completely discards the message
+	w, err = bq.NewMessageWriter(int64(len(msg)))
+	if err != nil {
+		t.Fatalf("NewMessageWriter failed :: %v", err)
+	}
+	if _, err := w.Write(msg[:arenaSize]); err != nil {
+		t.Fatalf("unable to write message :: %v", err)
+	}
+	if err := w.Close(); err != ErrMessageSize {
+		t.Fatalf("expected message size error, returned: %v", err)
+	}
+
+	if !bq.IsEmpty() {
+		t.Fatalf("discarded messages should not be visible")
+	}
+
+	// discarded bytes are overwritten by the next element
+	if err := bq.Enqueue(msg); err != nil {
+		t.Fatalf("enqueue failed :: %v", err)
+	}
+	if poppedMsg, err := bq.Dequeue(); err != nil {
+		t.Fatalf("unable to dequeue :: %v", err)
+	} else if !bytes.Equal(msg, poppedMsg) {
+		t.Fatalf("unequal messages")
+	}
+}
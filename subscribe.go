@@ -0,0 +1,232 @@
+package bigqueue
+
+import (
+	"context"
+)
+
+// subscribeConfig stores all the configuration related to a subscription.
+type subscribeConfig struct {
+	commitOnAck bool
+}
+
+// SubscribeOption is function type that takes a subscribeConfig object
+// and sets various subscription parameters in the object.
+type SubscribeOption func(*subscribeConfig)
+
+// SubscribeCommitOnAck returns a SubscribeOption that only moves the head of
+// the consumer when a message is acknowledged using Message.Ack. By default,
+// the head is moved as soon as a message is sent on the subscription channel.
+func SubscribeCommitOnAck() SubscribeOption {
+	return func(c *subscribeConfig) {
+		c.commitOnAck = true
+	}
+}
+
+// Message is an element of the queue along with its sequence number. Sequence
+// numbers start at 0 for the first element of a queue and are incremented by 1 for
+// every element, hence, they can be used to detect duplicate or missing elements.
+type Message struct {
+	Seq  uint64
+	Data []byte
+
+	q      *MmapQueue // nil unless the message needs to be acknowledged
+	base   int64
+	aid    int // position right after the element
+	offset int
+}
+
+// Ack moves the head of the consumer past this message. Acknowledgements are
+// cumulative, acknowledging a message also acknowledges all the messages that
+// were delivered before it. Acknowledging a message older than the current head
+// has no effect. Ack is a no-op for subscriptions that commit on send.
+func (m Message) Ack() error {
+	if m.q == nil {
+		return nil
+	}
+
+	m.q.lock.Lock()
+	defer m.q.lock.Unlock()
+
+	if m.q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	headAid, headOffset := m.q.getConsumerHead(m.base)
+	if comparePos(m.aid, m.offset, headAid, headOffset) > 0 {
+		m.q.moveHead(m.base, m.aid, m.offset, int(m.Seq+1-m.q.getConsumerSeq(m.base)))
+	}
+
+	return nil
+}
+
+// subscription reads elements of the queue in a background go routine.
+type subscription struct {
+	q      *MmapQueue
+	base   int64
+	conf   *subscribeConfig
+	msgs   chan Message
+	aid    int // position of the next element to read
+	offset int
+	seq    uint64
+
+	startAid    int // position of the last element read
+	startOffset int
+}
+
+// Subscribe starts a background go routine that reads elements from the queue and
+// sends them on the returned message channel, which is buffered with bufSize elements.
+// The go routine waits for new elements when the queue is empty. It stops once the
+// context is done or the queue is closed, and both channels are closed afterwards.
+// An error, including ErrQueueClosed, is sent on the error channel before it stops.
+//
+// By default, the head of the consumer is moved as soon as a message is sent on the
+// channel, hence, messages in the channel buffer are lost when the process crashes.
+// With SubscribeCommitOnAck, the head is only moved when a message is acknowledged
+// and unacknowledged messages are delivered again by the next subscription.
+// This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) Subscribe(ctx context.Context, bufSize int,
+	opts ...SubscribeOption) (<-chan Message, <-chan error) {
+	return q.subscribe(ctx, q.dc, bufSize, opts...)
+}
+
+func (q *MmapQueue) subscribe(ctx context.Context, base int64, bufSize int,
+	opts ...SubscribeOption) (<-chan Message, <-chan error) {
+	conf := &subscribeConfig{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+
+	s := &subscription{
+		q:    q,
+		base: base,
+		conf: conf,
+		msgs: make(chan Message, max(bufSize, 0)),
+	}
+	errs := make(chan error, 1)
+
+	// the go routine must be added to the wait group with lock held so that
+	// Close, which closes quit with the lock held, always waits for it.
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		errs <- ErrQueueClosed
+		close(errs)
+		close(s.msgs)
+		return s.msgs, errs
+	}
+
+	s.aid, s.offset = q.getConsumerHead(base)
+	s.seq = q.getConsumerSeq(base)
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		defer close(errs)
+		defer close(s.msgs)
+
+		if err := s.run(ctx); err != nil {
+			errs <- err
+		}
+	}()
+
+	return s.msgs, errs
+}
+
+// run reads and sends the elements until the context is done or the queue is closed.
+func (s *subscription) run(ctx context.Context) error {
+	for {
+		msg, err := s.next(ctx)
+		if err != nil {
+			if err == ctx.Err() {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case s.msgs <- msg:
+		case <-s.q.quit:
+			return ErrQueueClosed
+		case <-ctx.Done():
+			return nil
+		}
+
+		if !s.conf.commitOnAck {
+			s.commit(msg)
+		}
+	}
+}
+
+// next waits for and reads the next element after the cursor of the subscription.
+func (s *subscription) next(ctx context.Context) (Message, error) {
+	for {
+		s.q.lock.Lock()
+		if s.q.isClosed() {
+			s.q.lock.Unlock()
+			return Message{}, ErrQueueClosed
+		}
+
+		if err := s.q.dropExpired(s.base); err != nil {
+			s.q.lock.Unlock()
+			return Message{}, err
+		}
+
+		// the head may have been moved by another operation on the consumer.
+		headAid, headOffset := s.q.getConsumerHead(s.base)
+		if !s.conf.commitOnAck || comparePos(s.aid, s.offset, headAid, headOffset) < 0 {
+			s.aid, s.offset = headAid, headOffset
+			s.seq = s.q.getConsumerSeq(s.base)
+		}
+
+		// expired records after unacknowledged messages are skipped, they are
+		// dropped once the head moves past them, i.e. a message after them is acknowledged.
+		aid, offset, skipped, err := s.q.skipExpired(s.base, s.aid, s.offset, s.seq, true)
+		if err != nil {
+			s.q.lock.Unlock()
+			return Message{}, err
+		}
+		s.aid, s.offset = aid, offset
+		s.seq += uint64(skipped)
+
+		tailAid, tailOffset := s.q.md.getTail()
+		if s.aid != tailAid || s.offset != tailOffset {
+			var br bytesReader
+			aid, offset, err := s.q.readRecord(&br, s.aid, s.offset)
+			s.q.lock.Unlock()
+			if err != nil {
+				return Message{}, err
+			}
+
+			msg := Message{Seq: s.seq, Data: br.b, base: s.base, aid: aid, offset: offset}
+			if s.conf.commitOnAck {
+				msg.q = s.q
+			}
+
+			s.startAid, s.startOffset = s.aid, s.offset
+			s.aid, s.offset = aid, offset
+			s.seq++
+			return msg, nil
+		}
+
+		ch := s.q.enqueueNotifier()
+		s.q.lock.Unlock()
+		if err := s.q.waitFor(ctx, ch); err != nil {
+			return Message{}, err
+		}
+	}
+}
+
+// commit moves the head of the consumer past the message, unless
+// the head has been moved by another operation on the consumer.
+func (s *subscription) commit(msg Message) {
+	s.q.lock.Lock()
+	defer s.q.lock.Unlock()
+
+	if s.q.isClosed() {
+		return
+	}
+
+	if aid, offset := s.q.getConsumerHead(s.base); aid == s.startAid && offset == s.startOffset {
+		s.q.moveHead(s.base, msg.aid, msg.offset, 1)
+	}
+}
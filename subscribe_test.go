@@ -0,0 +1,161 @@
+package bigqueue
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("error in creating a consumer :: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs, errs := c.Subscribe(ctx, 0)
+
+	// messages enqueued after subscribing are delivered
+	exp := make([][]byte, 0, 10)
+	for i := range 10 {
+		msg := bytes.Repeat([]byte(strconv.Itoa(i)), 1000)
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+		exp = append(exp, msg)
+	}
+
+	for i := range exp {
+		msg := <-msgs
+		if !bytes.Equal(msg.Data, exp[i]) || msg.Seq != uint64(i) {
+			t.Fatalf("messages don't match for element %d", i)
+		}
+		if err := msg.Ack(); err != nil {
+			t.Fatalf("Ack should be a no-op :: %v", err)
+		}
+	}
+
+	cancel()
+	if _, ok := <-msgs; ok {
+		t.Fatalf("message channel should be closed")
+	}
+	if err, ok := <-errs; ok {
+		t.Fatalf("no error expected upon cancel, returned: %v", err)
+	}
+
+	if !c.IsEmpty() {
+		t.Fatalf("messages should be committed upon send")
+	}
+}
+
+func TestSubscribeCommitOnAck(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir, SetArenaSize(8*1024))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	exp := make([][]byte, 0, 10)
+	for i := range 10 {
+		msg := bytes.Repeat([]byte(strconv.Itoa(i)), 1000)
+		if err := bq.Enqueue(msg); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+		exp = append(exp, msg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs, errs := bq.Subscribe(ctx, 5, SubscribeCommitOnAck())
+	received := make([]Message, 0, len(exp))
+	for i := range exp {
+		msg := <-msgs
+		if !bytes.Equal(msg.Data, exp[i]) {
+			t.Fatalf("messages don't match for element %d", i)
+		}
+		received = append(received, msg)
+	}
+	cancel()
+	if err := <-errs; err != nil {
+		t.Fatalf("no error expected upon cancel, returned: %v", err)
+	}
+
+	// nothing is committed until a message is acknowledged
+	if msg, err := bq.Peek(); err != nil {
+		t.Fatalf("Peek failed :: %v", err)
+	} else if !bytes.Equal(msg, exp[0]) {
+		t.Fatalf("head should not move before Ack")
+	}
+
+	// acknowledgements are cumulative
+	if err := received[6].Ack(); err != nil {
+		t.Fatalf("Ack failed :: %v", err)
+	}
+	if err := received[2].Ack(); err != nil {
+		t.Fatalf("Ack failed :: %v", err)
+	}
+	if msg, err := bq.Peek(); err != nil {
+		t.Fatalf("Peek failed :: %v", err)
+	} else if !bytes.Equal(msg, exp[7]) {
+		t.Fatalf("head should move past the acknowledged message")
+	}
+
+	// unacknowledged messages are delivered again
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	msgs, _ = bq.Subscribe(ctx, 0, SubscribeCommitOnAck())
+	if msg := <-msgs; !bytes.Equal(msg.Data, exp[7]) {
+		t.Fatalf("unacknowledged message should be delivered again")
+	}
+}
+
+func TestSubscribeClose(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	bq, err := NewMmapQueue(testDir)
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+
+	msgs, errs := bq.Subscribe(context.Background(), 1)
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+
+	// Close waits for the subscription go routine to stop
+	if _, ok := <-msgs; ok {
+		t.Fatalf("message channel should be closed")
+	}
+	if err := <-errs; err != ErrQueueClosed {
+		t.Fatalf("expected queue closed error, returned: %v", err)
+	}
+
+	msgs, errs = bq.Subscribe(context.Background(), 1)
+	if _, ok := <-msgs; ok {
+		t.Fatalf("message channel should be closed")
+	}
+	if err := <-errs; err != ErrQueueClosed {
+		t.Fatalf("expected queue closed error, returned: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package bigqueue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	cIndexFileName  = "index.dat"
+	cIndexEntrySize = 4 * cInt64Size
+)
+
+// indexEntry stores the position, the sequence number and the
+// enqueue time (in nanoseconds) of the first element of an arena.
+type indexEntry struct {
+	aid    int
+	offset int
+	seq    uint64
+	ts     int64
+}
+
+// timeIndex is a sparse index that stores one entry per arena in which elements
+// are enqueued. Entries are appended to the index file in the order of the arenas,
+// hence, they are also ordered by their enqueue time. The index is only used to
+// find a position to start scanning elements from, missing entries are allowed.
+type timeIndex struct {
+	fd      *os.File
+	entries []indexEntry
+}
+
+// newTimeIndex opens or creates the index file in given directory and loads it.
+// Entries at or after the tail of the queue are of elements that were never
+// published, e.g. because of a crash before the metadata was flushed, and are dropped.
+func newTimeIndex(dir string, tailAid, tailOffset int) (*timeIndex, error) {
+	fd, err := os.OpenFile(filepath.Join(dir, cIndexFileName), os.O_CREATE|os.O_RDWR, cFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("error in creating/opening index file :: %w", err)
+	}
+
+	data, err := io.ReadAll(fd)
+	if err != nil {
+		_ = fd.Close()
+		return nil, fmt.Errorf("error in reading index file :: %w", err)
+	}
+
+	// a partially written entry at the end of the file is ignored and overwritten.
+	ti := &timeIndex{fd: fd, entries: make([]indexEntry, 0, len(data)/cIndexEntrySize)}
+	for ; len(data) >= cIndexEntrySize; data = data[cIndexEntrySize:] {
+		e := indexEntry{
+			aid:    int(binary.LittleEndian.Uint64(data)),
+			offset: int(binary.LittleEndian.Uint64(data[8:])),
+			seq:    binary.LittleEndian.Uint64(data[16:]),
+			ts:     int64(binary.LittleEndian.Uint64(data[24:])),
+		}
+		if comparePos(e.aid, e.offset, tailAid, tailOffset) >= 0 {
+			break
+		}
+		ti.entries = append(ti.entries, e)
+	}
+
+	if err := fd.Truncate(int64(len(ti.entries) * cIndexEntrySize)); err != nil {
+		_ = fd.Close()
+		return nil, fmt.Errorf("error in truncating index file :: %w", err)
+	}
+
+	return ti, nil
+}
+
+// add adds an entry for the element at given position, unless
+// an entry for the arena of the position already exists.
+func (ti *timeIndex) add(aid, offset int, seq uint64, ts time.Time) error {
+	if n := len(ti.entries); n > 0 && ti.entries[n-1].aid >= aid {
+		return nil
+	}
+
+	e := indexEntry{aid: aid, offset: offset, seq: seq, ts: ts.UnixNano()}
+	var data [cIndexEntrySize]byte
+	binary.LittleEndian.PutUint64(data[:], uint64(e.aid))
+	binary.LittleEndian.PutUint64(data[8:], uint64(e.offset))
+	binary.LittleEndian.PutUint64(data[16:], e.seq)
+	binary.LittleEndian.PutUint64(data[24:], uint64(e.ts))
+	if _, err := ti.fd.WriteAt(data[:], int64(len(ti.entries)*cIndexEntrySize)); err != nil {
+		return fmt.Errorf("error in writing index file :: %w", err)
+	}
+
+	ti.entries = append(ti.entries, e)
+	return nil
+}
+
+// before returns the last entry of an element enqueued before given time.
+// It returns false if no such entry exists in the index.
+func (ti *timeIndex) before(t time.Time) (indexEntry, bool) {
+	ns := t.UnixNano()
+	i := sort.Search(len(ti.entries), func(i int) bool { return ti.entries[i].ts >= ns })
+	if i == 0 {
+		return indexEntry{}, false
+	}
+
+	return ti.entries[i-1], true
+}
+
+// atOrBefore returns the last entry of an element at or before given position.
+// It returns false if no such entry exists in the index.
+func (ti *timeIndex) atOrBefore(aid, offset int) (indexEntry, bool) {
+	i := sort.Search(len(ti.entries), func(i int) bool {
+		return comparePos(ti.entries[i].aid, ti.entries[i].offset, aid, offset) > 0
+	})
+	if i == 0 {
+		return indexEntry{}, false
+	}
+
+	return ti.entries[i-1], true
+}
+
+// flush writes the index file on to disk.
+func (ti *timeIndex) flush() error {
+	if err := ti.fd.Sync(); err != nil {
+		return fmt.Errorf("error in syncing index file :: %w", err)
+	}
+
+	return nil
+}
+
+// close closes the index file.
+func (ti *timeIndex) close() error {
+	if err := ti.fd.Close(); err != nil {
+		return fmt.Errorf("error in closing index file :: %w", err)
+	}
+
+	return nil
+}
+
+// indexTail adds an entry for the element that is about to be published at the
+// tail of the queue. The index is only used to speed up seeking, an element that
+// is not indexed is still found by scanning, hence, errors are ignored.
+func (q *MmapQueue) indexTail(ts time.Time) {
+	aid, offset := q.md.getTail()
+	_ = q.ti.add(aid, offset, q.md.getNextSeq(), ts)
+}
+
+// SeekToTime moves the head of the queue to the first element enqueued at or after
+// given time, the head may also move backwards. The index of the queue stores the
+// enqueue time of the first element of each arena, scanning starts from the last
+// arena with an element enqueued before given time. Within the arena, the timestamp
+// of records is used, see EnqueueRecord. The scan stops at the first element without
+// timestamp, hence, such elements are only positioned as precisely as the index.
+// Timestamps of elements are expected to increase from head to tail of the queue.
+// This function uses the default consumer to consume from the queue.
+func (q *MmapQueue) SeekToTime(t time.Time) error {
+	return q.seekToTime(q.dc, t)
+}
+
+func (q *MmapQueue) seekToTime(base int64, t time.Time) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isClosed() {
+		return ErrQueueClosed
+	}
+
+	aid, offset := q.md.getHead()
+	seq := q.md.getHeadSeq()
+	if e, ok := q.ti.before(t); ok {
+		aid, offset, seq = e.aid, e.offset, e.seq
+	}
+
+	tailAid, tailOffset := q.md.getTail()
+	for aid != tailAid || offset != tailOffset {
+		r, dataAid, dataOffset, length, err := q.readEnvelope(aid, offset)
+		if err != nil {
+			return err
+		}
+		if r.Timestamp.IsZero() || !r.Timestamp.Before(t) {
+			break
+		}
+
+		aid, offset = q.advance(dataAid, dataOffset, length)
+		seq++
+	}
+
+	q.putConsumerHead(base, aid, offset)
+	q.putConsumerSeq(base, seq)
+	q.incrMutOps()
+	q.notifyEnqueue()
+	return nil
+}
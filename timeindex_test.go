@@ -0,0 +1,137 @@
+package bigqueue
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSeekToTime(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+
+	// 40 records of 1000 bytes span several arenas
+	base := time.Unix(1700000000, 0)
+	for i := range 40 {
+		rec := Record{
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+			Data:      bytes.Repeat([]byte(strconv.Itoa(i)), 1000),
+		}
+		if err := bq.EnqueueRecord(rec); err != nil {
+			t.Fatalf("EnqueueRecord failed :: %v", err)
+		}
+	}
+	if len(bq.ti.entries) < 4 {
+		t.Fatalf("index should have an entry per arena, entries: %d", len(bq.ti.entries))
+	}
+
+	seek := func(c *Consumer, ts time.Time, seq uint64) {
+		t.Helper()
+
+		if err := c.SeekToTime(ts); err != nil {
+			t.Fatalf("SeekToTime failed :: %v", err)
+		}
+		msg, err := c.DequeueMsg()
+		if err != nil {
+			t.Fatalf("DequeueMsg failed :: %v", err)
+		}
+		if msg.Seq != seq || !bytes.Equal(msg.Data, bytes.Repeat([]byte(strconv.Itoa(int(seq))), 1000)) {
+			t.Fatalf("expected element %d after seeking to %v, got: %d", seq, ts, msg.Seq)
+		}
+	}
+
+	c, err := bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("unable to create consumer :: %v", err)
+	}
+	seek(c, base.Add(25*time.Second), 25)
+	seek(c, base.Add(25*time.Second+time.Millisecond), 26)
+	seek(c, base.Add(-time.Hour), 0)
+	seek(c, base.Add(39*time.Second), 39)
+
+	// head of the consumer also moves backwards
+	seek(c, base.Add(3*time.Second), 3)
+
+	// seeking after all the elements empties the consumer
+	if err := c.SeekToTime(base.Add(time.Hour)); err != nil {
+		t.Fatalf("SeekToTime failed :: %v", err)
+	}
+	if !c.IsEmpty() {
+		t.Fatalf("consumer should be empty after seeking past all elements")
+	}
+
+	// index is persisted across reopening the queue
+	if err := bq.Close(); err != nil {
+		t.Fatalf("error in closing bigqueue :: %v", err)
+	}
+	bq, err = NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	if len(bq.ti.entries) < 4 {
+		t.Fatalf("index should be loaded, entries: %d", len(bq.ti.entries))
+	}
+	c, err = bq.NewConsumer("consumer")
+	if err != nil {
+		t.Fatalf("unable to get consumer :: %v", err)
+	}
+	seek(c, base.Add(17*time.Second), 17)
+}
+
+func TestSeekToTimePlain(t *testing.T) {
+	t.Parallel()
+
+	testDir := t.TempDir()
+	arenaSize := 8 * 1024
+	bq, err := NewMmapQueue(testDir, SetArenaSize(arenaSize))
+	if err != nil {
+		t.Fatalf("unable to get BigQueue :: %v", err)
+	}
+	defer func() {
+		if err := bq.Close(); err != nil {
+			t.Fatalf("error in closing bigqueue :: %v", err)
+		}
+	}()
+
+	before := time.Now()
+	for i := range 40 {
+		if err := bq.Enqueue(bytes.Repeat([]byte(strconv.Itoa(i)), 1000)); err != nil {
+			t.Fatalf("enqueue failed :: %v", err)
+		}
+	}
+
+	// elements without timestamp are positioned at the start of their arena
+	if err := bq.SeekToTime(time.Now()); err != nil {
+		t.Fatalf("SeekToTime failed :: %v", err)
+	}
+	last := bq.ti.entries[len(bq.ti.entries)-1]
+	msg, err := bq.DequeueMsg()
+	if err != nil {
+		t.Fatalf("DequeueMsg failed :: %v", err)
+	}
+	if msg.Seq == 0 || msg.Seq != last.seq {
+		t.Fatalf("expected first element of the last arena %d, got: %d", last.seq, msg.Seq)
+	}
+
+	if err := bq.SeekToTime(before); err != nil {
+		t.Fatalf("SeekToTime failed :: %v", err)
+	}
+	if msg, err := bq.DequeueMsg(); err != nil {
+		t.Fatalf("DequeueMsg failed :: %v", err)
+	} else if msg.Seq != 0 {
+		t.Fatalf("expected first element after seeking before all elements, got: %d", msg.Seq)
+	}
+}
@@ -1,7 +1,13 @@
 package bigqueue
 
+import (
+	"time"
+)
+
 // Enqueue adds a new slice of byte element to the tail of the queue.
 func (q *MmapQueue) Enqueue(message []byte) error {
+	q.wlock.Lock()
+	defer q.wlock.Unlock()
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
@@ -11,8 +17,30 @@ func (q *MmapQueue) Enqueue(message []byte) error {
 	return err
 }
 
+// EnqueueWithPosition adds a new slice of byte element to the tail of
+// the queue and returns the position of the element in the queue.
+func (q *MmapQueue) EnqueueWithPosition(message []byte) (Position, error) {
+	q.wlock.Lock()
+	defer q.wlock.Unlock()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	aid, offset := q.md.getTail()
+	seq := q.md.getNextSeq()
+	q.bw.b = message
+	err := q.enqueue(&q.bw)
+	q.bw.b = nil
+	if err != nil {
+		return Position{}, err
+	}
+
+	return Position{id: q.id, aid: aid, offset: offset, seq: seq}, nil
+}
+
 // EnqueueString adds a new string element to the tail of the queue.
 func (q *MmapQueue) EnqueueString(message string) error {
+	q.wlock.Lock()
+	defer q.wlock.Unlock()
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
@@ -22,27 +50,84 @@ func (q *MmapQueue) EnqueueString(message string) error {
 	return err
 }
 
+// EnqueueV adds a new element formed by concatenating all the given slices
+// to the tail of the queue. The slices are written back to back into the
+// queue, avoiding an allocation and a copy to concatenate them beforehand.
+func (q *MmapQueue) EnqueueV(parts ...[]byte) error {
+	q.wlock.Lock()
+	defer q.wlock.Unlock()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.vw.parts, q.vw.arenaSize = parts, q.conf.arenaSize
+	err := q.enqueue(&q.vw)
+	q.vw.parts = nil
+	return err
+}
+
+// EnqueueBatch adds all the given elements to the tail of the queue atomically.
+// All the elements are written first and then the tail is moved only once, hence,
+// either all of the elements become visible to consumers or none of them does,
+// for example, when a new arena cannot be allocated while writing the elements.
+func (q *MmapQueue) EnqueueBatch(messages [][]byte) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	q.wlock.Lock()
+	defer q.wlock.Unlock()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var err error
+	aid, offset := q.md.getTail()
+	for _, message := range messages {
+		q.bw.b = message
+		aid, offset, err = q.writeRecord(&q.bw, aid, offset)
+		q.bw.b = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	q.publish(aid, offset, len(messages))
+	return nil
+}
+
 // enqueue writes the data hold by the given writer. It first writes the length
 // of the data, then the data itself. It is possible that the whole data may not
 // fit into one arena. This function takes care of spreading the data across
 // multiple arenas when necessary.
 func (q *MmapQueue) enqueue(w writer) error {
-	var err error
 	aid, offset := q.md.getTail()
-	aid, offset, err = q.writeLength(aid, offset, uint64(w.len()))
+	aid, offset, err := q.writeRecord(w, aid, offset)
 	if err != nil {
 		return err
 	}
 
-	aid, offset, err = q.writeBytes(w, aid, offset)
-	if err != nil {
-		return err
-	}
+	q.publish(aid, offset, 1)
+	return nil
+}
 
+// publish moves the tail of the queue past n new elements, making
+// them visible to consumers, and wakes up the waiting consumers.
+func (q *MmapQueue) publish(aid, offset, n int) {
+	q.indexTail(time.Now())
 	q.md.putTail(aid, offset)
+	q.md.putNextSeq(q.md.getNextSeq() + uint64(n))
 	q.incrMutOps()
+	q.notifyEnqueue()
+}
 
-	return nil
+// writeRecord writes the length followed by the data hold by the given writer
+// starting at given position, and returns the position right after the record.
+func (q *MmapQueue) writeRecord(w writer, aid, offset int) (int, int, error) {
+	aid, offset, err := q.writeLength(aid, offset, uint64(w.len()))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return q.writeBytes(w, aid, offset)
 }
 
 // writeLength writes the length into tail arena. Note that length is
@@ -1,9 +1,5 @@
 package bigqueue
 
-import (
-	"github.com/grandecola/mmap"
-)
-
 // writer knows how to copy data of given length to arena.
 type writer interface {
 	// len returns the length of the data that writer holds.
@@ -13,7 +9,7 @@ type writer interface {
 	// whole data that writer holds may not fit in the given arena. Hence, an index
 	// into the data is provided. The data is copied starting from index until either
 	// no more data is left, or no space is left in the given arena to write more data.
-	writeTo(aa *mmap.File, offset, index int) int
+	writeTo(aa *arena, offset, index int) int
 }
 
 // bytesWriter holds a slice of bytes and satisfies the bigqueue.writer interface.
@@ -28,7 +24,7 @@ func (bw *bytesWriter) len() int {
 
 // writeTo writes data that it holds from index to end of
 // the data or arena, into the arena starting at the offset.
-func (bw *bytesWriter) writeTo(aa *mmap.File, offset, index int) int {
+func (bw *bytesWriter) writeTo(aa *arena, offset, index int) int {
 	n, _ := aa.WriteAt(bw.b[index:], int64(offset))
 	return n
 }
@@ -45,6 +41,46 @@ func (sw *stringWriter) len() int {
 
 // writeTo writes the string starting from index into arena
 // starting at offset until either arena lasts or string lasts.
-func (sw *stringWriter) writeTo(aa *mmap.File, offset, index int) int {
+func (sw *stringWriter) writeTo(aa *arena, offset, index int) int {
 	return aa.WriteStringAt(sw.s[index:], int64(offset))
 }
+
+// vecWriter holds multiple slices of bytes that are written back to back
+// as one element, and satisfies the bigqueue.writer interface.
+type vecWriter struct {
+	parts     [][]byte
+	arenaSize int
+}
+
+// len returns the total length of all the slices that vecWriter holds.
+func (vw *vecWriter) len() int {
+	length := 0
+	for _, part := range vw.parts {
+		length += len(part)
+	}
+	return length
+}
+
+// writeTo writes data that it holds from index to end of the data or
+// arena, into the arena starting at the offset. The index is an index
+// into the data formed by concatenating all the slices.
+func (vw *vecWriter) writeTo(aa *arena, offset, index int) int {
+	written := 0
+	for _, part := range vw.parts {
+		if index >= len(part) {
+			index -= len(part)
+			continue
+		}
+
+		// the arena is full
+		if offset+written == vw.arenaSize {
+			break
+		}
+
+		n, _ := aa.WriteAt(part[index:], int64(offset+written))
+		written += n
+		index = 0
+	}
+
+	return written
+}